@@ -17,6 +17,7 @@ package merger
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/streamingfast/bstream"
@@ -30,18 +31,97 @@ import (
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+const DefaultBundleSize = uint64(100)
+
+// NotifierQueueSize is the number of bundle notifications that can wait for delivery, per notifier
+const NotifierQueueSize = 1000
+
+// LeaseName is the name of the lease object in the merged blocks store
+const LeaseName = "merger.lease"
+
 type Config struct {
 	StorageOneBlockFilesPath     string
 	StorageMergedBlocksFilesPath string
 	StorageForkedBlocksFilesPath string
 
+	// OneBlockFilesCompression, MergedBlocksFilesCompression and ForkedBlocksFilesCompression choose how objects are
+	// written in each store: `zstd`, `gzip` or `none`, optionally with a level (ex: `zstd:19`). Objects keep the
+	// `dbin.zst` extension and are decoded from their content, so a store can hold objects of several compressions.
+	// Empty keeps the default (zstd).
+	OneBlockFilesCompression     string
+	MergedBlocksFilesCompression string
+	ForkedBlocksFilesCompression string
+
 	GRPCListenAddr string
 
+	// StatusListenAddr serves the status of the merger as JSON over HTTP, ex: `/status/sources` (optional)
+	StatusListenAddr string
+
 	PruneForkedBlocksAfter uint64
 
+	// BundleSize is the number of blocks in each merged file, defaults to 100. It must match the existing merged files.
+	BundleSize uint64
+
 	TimeBetweenPruning time.Duration
 	TimeBetweenPolling time.Duration
 	StopBlock          uint64
+
+	// OneBlockDownloadParallelism and OneBlockDownloadWindow bound the pre-download of irreversible one-block-files,
+	// see merger.ParallelOneBlockDownload and merger.OneBlockDownloadWindow (0 keeps the defaults)
+	OneBlockDownloadParallelism int
+	OneBlockDownloadWindow      int
+
+	// ReorgDepthWarningThreshold logs a warning for each fork at least that deep (0 disables it)
+	ReorgDepthWarningThreshold uint64
+
+	// StorageForkArchivesPath is the dstore URL where the forked blocks of each bundle are written as a single fork
+	// archive, instead of one object per block in the forked blocks store (optional)
+	StorageForkArchivesPath string
+
+	// ForkLog writes the forked blocks found in each bundle as a JSONL object (`0000012300.forks.jsonl`) next to the merged files
+	ForkLog bool
+
+	// DryRun lists, downloads and bundles blocks like a normal run, but never writes nor deletes anything in the stores.
+	// The merged files that would be written and the files that would be deleted or moved are printed to stdout instead
+	DryRun bool
+
+	// WatchOneBlockFiles passes new one-block-files to the bundler as soon as they are written, for local (file://)
+	// one-block stores on linux. The polling every TimeBetweenPolling is kept as a fallback
+	WatchOneBlockFiles bool
+
+	// CompareOneBlockSources downloads the one-block-files of every source for each block and reports the sources that
+	// disagree on its content, keeping the content written by the most sources
+	CompareOneBlockSources bool
+
+	// VerifyMergedUpload reads back each merged file after writing it, rewriting it if its blocks do not match
+	VerifyMergedUpload bool
+
+	// BundleIndex writes an index sidecar (`.index.json`) next to each merged file, listing its blocks with their offset and checksum
+	BundleIndex bool
+
+	// NotifyWebhookURL receives a JSON POST after each merged file is written (optional)
+	NotifyWebhookURL string
+	// NotifyFilePath gets a JSON line appended after each merged file is written (optional)
+	NotifyFilePath string
+
+	// HeadStallThreshold makes the merger unhealthy when no block was processed for that long, 0 disables the check
+	HeadStallThreshold time.Duration
+
+	// HoleMode is one of "warn" (default), "start-at-first-hole" or "skip", see merger.HoleMode
+	HoleMode string
+
+	// StateFile is a local path or a dstore URL where the bundler checkpoint is kept, for faster restarts (optional)
+	StateFile string
+
+	// LeaseDuration enables leader election between merger replicas, through a lease object kept in the merged blocks store.
+	// Only the lease holder merges and prunes, standby replicas take over when the lease expires. 0 disables it.
+	LeaseDuration time.Duration
+	// LeaseHolderID identifies this replica in the lease, defaults to hostname and pid
+	LeaseHolderID string
+
+	// BackfillHoles runs the merger once to rebuild the missing bundles from one-block-files, instead of merging live.
+	// With LeaseDuration, it waits for the lease like a live replica before writing anything
+	BackfillHoles bool
 }
 
 type App struct {
@@ -62,25 +142,104 @@ func (a *App) Run() error {
 
 	dmetrics.Register(metrics.MetricSet)
 
-	oneBlockStoreStore, err := dstore.NewDBinStore(a.config.StorageOneBlockFilesPath)
+	oneBlockStoreStore, err := NewDBinStore(a.config.StorageOneBlockFilesPath, a.config.OneBlockFilesCompression)
 	if err != nil {
 		return fmt.Errorf("failed to init source archive store: %w", err)
 	}
 
-	mergedBlocksStore, err := dstore.NewDBinStore(a.config.StorageMergedBlocksFilesPath)
+	mergedBlocksStore, err := NewDBinStore(a.config.StorageMergedBlocksFilesPath, a.config.MergedBlocksFilesCompression)
 	if err != nil {
 		return fmt.Errorf("failed to init destination archive store: %w", err)
 	}
 
 	var forkedBlocksStore dstore.Store
 	if a.config.StorageForkedBlocksFilesPath != "" {
-		forkedBlocksStore, err = dstore.NewDBinStore(a.config.StorageForkedBlocksFilesPath)
+		forkedBlocksStore, err = NewDBinStore(a.config.StorageForkedBlocksFilesPath, a.config.ForkedBlocksFilesCompression)
 		if err != nil {
 			return fmt.Errorf("failed to init destination archive store: %w", err)
 		}
 	}
 
-	bundleSize := uint64(100)
+	var forkArchiveStore dstore.Store
+	if a.config.StorageForkArchivesPath != "" {
+		forkArchiveStore, err = merger.NewForkArchiveStore(a.config.StorageForkArchivesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	holeMode, err := merger.ParseHoleMode(a.config.HoleMode)
+	if err != nil {
+		return err
+	}
+
+	var stateFile *merger.StateFile
+	if a.config.StateFile != "" {
+		stateFile, err = merger.NewStateFile(a.config.StateFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var lease *merger.Lease
+	if a.config.LeaseDuration != 0 {
+		leaseStore, err := dstore.NewStore(a.config.StorageMergedBlocksFilesPath, "", "", true)
+		if err != nil {
+			return fmt.Errorf("failed to init lease store: %w", err)
+		}
+		holderID := a.config.LeaseHolderID
+		if holderID == "" {
+			hostname, _ := os.Hostname()
+			holderID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		lease = merger.NewLease(zlog, leaseStore, LeaseName, holderID, a.config.LeaseDuration)
+	}
+
+	bundleSize := a.config.BundleSize
+	if bundleSize == 0 {
+		bundleSize = DefaultBundleSize
+	}
+	if err := merger.CheckBundleSize(context.Background(), mergedBlocksStore, bundleSize); err != nil {
+		return fmt.Errorf("refusing to start with bundle size %d: %w", bundleSize, err)
+	}
+
+	var notifiers merger.MultiNotifier
+	if a.config.NotifyWebhookURL != "" {
+		notifiers = append(notifiers, merger.NewAsyncNotifier(zlog, "webhook", merger.NewWebhookNotifier(a.config.NotifyWebhookURL), NotifierQueueSize, 5, time.Second))
+	}
+	if a.config.NotifyFilePath != "" {
+		notifiers = append(notifiers, merger.NewAsyncNotifier(zlog, "file", merger.NewFileNotifier(a.config.NotifyFilePath), NotifierQueueSize, 5, time.Second))
+	}
+	var ioOptions []merger.DStoreIOOption
+	if len(notifiers) != 0 {
+		ioOptions = append(ioOptions, merger.WithBundleNotifier(notifiers))
+	}
+
+	if a.config.BundleIndex {
+		indexStore, err := merger.NewBundleIndexStore(a.config.StorageMergedBlocksFilesPath)
+		if err != nil {
+			return err
+		}
+		ioOptions = append(ioOptions, merger.WithBundleIndexStore(indexStore))
+	}
+	if a.config.VerifyMergedUpload {
+		ioOptions = append(ioOptions, merger.WithMergedUploadVerification())
+	}
+	if forkArchiveStore != nil {
+		ioOptions = append(ioOptions, merger.WithForkArchiveStore(forkArchiveStore))
+	}
+	if a.config.CompareOneBlockSources {
+		ioOptions = append(ioOptions, merger.WithOneBlockSourcesComparison())
+	}
+
+	var forkLog *merger.ForkLog
+	if a.config.ForkLog {
+		forkLogStore, err := merger.NewForkLogStore(a.config.StorageMergedBlocksFilesPath)
+		if err != nil {
+			return err
+		}
+		forkLog = merger.NewForkLog(zlog, forkLogStore)
+	}
 
 	// we are setting the backoff here for dstoreIO
 	io := merger.NewDStoreIO(
@@ -91,7 +250,40 @@ func (a *App) Run() error {
 		forkedBlocksStore,
 		5,
 		500*time.Millisecond,
-		bundleSize)
+		bundleSize,
+		ioOptions...,
+	)
+
+	if a.config.DryRun {
+		// nothing else may write to the stores either
+		zlog.Info("dry-run: printing the plan instead of writing and deleting files, state file, fork log and lease are disabled")
+		io = merger.NewDryRunIO(io, os.Stdout)
+		stateFile = nil
+		forkLog = nil
+		lease = nil
+	}
+
+	mergerOptions := []merger.MergerOption{
+		merger.WithHoleMode(holeMode),
+		merger.WithHeadStallThreshold(a.config.HeadStallThreshold),
+		merger.WithStatusListenAddr(a.config.StatusListenAddr),
+		merger.WithBundlerOptions(
+			merger.WithOneBlockPreDownload(a.config.OneBlockDownloadParallelism, a.config.OneBlockDownloadWindow),
+			merger.WithReorgDepthWarningThreshold(a.config.ReorgDepthWarningThreshold),
+		),
+	}
+	if stateFile != nil {
+		mergerOptions = append(mergerOptions, merger.WithStateFile(stateFile))
+	}
+	if lease != nil {
+		mergerOptions = append(mergerOptions, merger.WithLease(lease))
+	}
+	if forkLog != nil {
+		mergerOptions = append(mergerOptions, merger.WithForkLog(forkLog))
+	}
+	if a.config.WatchOneBlockFiles {
+		mergerOptions = append(mergerOptions, merger.WithOneBlockFilesWatcher())
+	}
 
 	m := merger.NewMerger(
 		zlog,
@@ -103,6 +295,7 @@ func (a *App) Run() error {
 		a.config.TimeBetweenPruning,
 		a.config.TimeBetweenPolling,
 		a.config.StopBlock,
+		mergerOptions...,
 	)
 	zlog.Info("merger initiated")
 
@@ -115,7 +308,11 @@ func (a *App) Run() error {
 	a.OnTerminating(m.Shutdown)
 	m.OnTerminated(a.Shutdown)
 
-	go m.Run()
+	if a.config.BackfillHoles {
+		go m.RunBackfill()
+	} else {
+		go m.Run()
+	}
 
 	zlog.Info("merger running")
 	return nil
@@ -138,3 +335,16 @@ func (a *App) IsReady() bool {
 
 	return false
 }
+
+// NewDBinStore opens a dbin store with the compression of a Config (`zstd`, `gzip` or `none`, with an optional level),
+// "" is zstd like dstore.NewDBinStore. The store reports the size of the objects it writes, after compression.
+func NewDBinStore(url, compression string) (dstore.Store, error) {
+	if compression == "" {
+		compression = merger.CompressionZstd
+	}
+	c, err := merger.ParseCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+	return merger.NewDBinStoreWithCompression(url, c)
+}
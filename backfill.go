@@ -0,0 +1,223 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// BackfillHole is a hole in the merged blocks store, with the bundles that could be rebuilt from one-block-files
+type BackfillHole struct {
+	LowBlockNum  uint64
+	HighBlockNum uint64
+	Filled       []uint64 // base block num of the bundles that were written
+	Err          error    // why the hole could not be completely filled
+}
+
+func (h *BackfillHole) Complete() bool {
+	return h.Err == nil
+}
+
+type BackfillSummary struct {
+	Holes []*BackfillHole
+}
+
+func (s *BackfillSummary) filledBundles() (out int) {
+	for _, h := range s.Holes {
+		out += len(h.Filled)
+	}
+	return
+}
+
+func (s *BackfillSummary) incompleteHoles() (out int) {
+	for _, h := range s.Holes {
+		if !h.Complete() {
+			out++
+		}
+	}
+	return
+}
+
+// RunBackfill fills the holes in the merged blocks store then shuts down the merger, it does not start the live merging.
+// With a lease, it waits to hold it like Run, so that it never writes merged files next to an active merger.
+func (m *Merger) RunBackfill() {
+	m.logger.Info("starting merger in backfill mode")
+
+	if m.lease != nil {
+		if !m.waitForLease() {
+			m.Shutdown(nil)
+			return
+		}
+		m.keepLease()
+	}
+
+	summary, err := m.Backfill(context.Background())
+	if err != nil {
+		m.logger.Error("backfill returned error", zap.Error(err))
+	}
+	if summary != nil {
+		for _, hole := range summary.Holes {
+			fields := []zap.Field{
+				zap.Uint64("low_block_num", hole.LowBlockNum),
+				zap.Uint64("high_block_num", hole.HighBlockNum),
+				zap.Uint64s("filled_bundles", hole.Filled),
+			}
+			if hole.Complete() {
+				m.logger.Info("hole filled", fields...)
+				continue
+			}
+			m.logger.Warn("hole could not be filled completely", append(fields, zap.Error(hole.Err))...)
+		}
+		m.logger.Info("backfill completed",
+			zap.Int("holes", len(summary.Holes)),
+			zap.Int("filled_bundles", summary.filledBundles()),
+			zap.Int("incomplete_holes", summary.incompleteHoles()),
+		)
+	}
+	m.Shutdown(err)
+}
+
+// Backfill finds every hole in the merged blocks store below the head, and tries to rebuild the missing
+// bundles from the one-block-files. Existing bundles are never overwritten.
+func (m *Merger) Backfill(ctx context.Context) (*BackfillSummary, error) {
+	holes, head, err := m.findHoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("finding holes: %w", err)
+	}
+	m.logger.Info("found holes in merged files", zap.Int("count", len(holes)), zap.Uint64("head_base_block_num", head))
+
+	summary := &BackfillSummary{}
+	for _, hole := range holes {
+		if m.IsTerminating() {
+			break
+		}
+		summary.Holes = append(summary.Holes, hole.BackfillHole)
+		m.backfillHole(ctx, hole)
+	}
+	return summary, nil
+}
+
+type holeWithLIB struct {
+	*BackfillHole
+	lib bstream.BlockRef
+}
+
+// findHoles walks the merged files like NextBundle does, jumping over each hole until it reaches the head
+func (m *Merger) findHoles(ctx context.Context) (holes []*holeWithLIB, head uint64, err error) {
+	return findHoles(ctx, m.io, toBaseNum(m.firstStreamableBlock, m.bundler.bundleSize))
+}
+
+// FindHoles lists the holes in the merged blocks store from lowestBaseBlock, with the base block of the
+// bundle that comes after the last merged file
+func FindHoles(ctx context.Context, io IOInterface, lowestBaseBlock uint64) (holes []*HoleError, head uint64, err error) {
+	found, head, err := findHoles(ctx, io, lowestBaseBlock)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, h := range found {
+		holes = append(holes, &HoleError{LowBlockNum: h.LowBlockNum, HighBlockNum: h.HighBlockNum})
+	}
+	return holes, head, nil
+}
+
+func findHoles(ctx context.Context, io IOInterface, base uint64) (holes []*holeWithLIB, head uint64, err error) {
+	for {
+		next, lib, err := io.NextBundle(ctx, base)
+		var holeErr *HoleError
+		if errors.As(err, &holeErr) {
+			holes = append(holes, &holeWithLIB{
+				BackfillHole: &BackfillHole{LowBlockNum: holeErr.LowBlockNum, HighBlockNum: holeErr.HighBlockNum},
+				lib:          lib,
+			})
+			base = holeErr.HighBlockNum
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return holes, next, nil
+	}
+}
+
+func (m *Merger) backfillHole(ctx context.Context, hole *holeWithLIB) {
+	logger := m.logger.With(zap.Uint64("low_block_num", hole.LowBlockNum), zap.Uint64("high_block_num", hole.HighBlockNum))
+	logger.Info("backfilling hole", zap.Stringer("lib", hole.lib))
+
+	io := &boundedIO{
+		IOInterface:  m.io,
+		lowBlockNum:  hole.LowBlockNum,
+		highBlockNum: hole.HighBlockNum,
+		logger:       logger,
+	}
+	bundler := NewBundler(logger, hole.LowBlockNum, hole.HighBlockNum, m.firstStreamableBlock, m.bundler.bundleSize, io, m.bundlerOptions...)
+	if hole.lib != nil {
+		bundler.Reset(hole.LowBlockNum, hole.lib)
+	}
+
+	err := m.io.WalkOneBlockFiles(ctx, hole.LowBlockNum, func(obf *bstream.OneBlockFile) error {
+		if m.IsTerminating() {
+			return fmt.Errorf("merger is terminating")
+		}
+		return bundler.HandleBlockFile(obf)
+	})
+
+	bundler.inProcess.Lock() // wait for the last bundle to be written
+	bundler.inProcess.Unlock()
+	bundler.Close()
+	hole.Filled = io.written
+
+	select {
+	case bundleErr := <-bundler.bundleError:
+		hole.Err = bundleErr
+		return
+	default:
+	}
+
+	switch {
+	case errors.Is(err, ErrStopBlockReached):
+		return
+	case err != nil:
+		hole.Err = err
+	default:
+		hole.Err = fmt.Errorf("not enough one-block-files to fill the hole, bundler stopped at %s", bundler)
+	}
+}
+
+// boundedIO only lets MergeAndStore write bundles inside a hole, so existing bundles are never overwritten.
+// It does not implement ForkAwareIOInterface: forked blocks are left in the one-block-files store.
+type boundedIO struct {
+	IOInterface
+	lowBlockNum  uint64
+	highBlockNum uint64
+	written      []uint64
+	logger       *zap.Logger
+}
+
+func (b *boundedIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+	if inclusiveLowerBlock < b.lowBlockNum || inclusiveLowerBlock >= b.highBlockNum {
+		b.logger.Debug("not writing bundle outside of hole", zap.Uint64("base_block_num", inclusiveLowerBlock))
+		return nil
+	}
+	if err := b.IOInterface.MergeAndStore(ctx, inclusiveLowerBlock, oneBlockFiles); err != nil {
+		return err
+	}
+	b.written = append(b.written, inclusiveLowerBlock)
+	return nil
+}
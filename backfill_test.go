@@ -0,0 +1,143 @@
+package merger
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergerBackfill(t *testing.T) {
+	tests := []struct {
+		name         string
+		oneBlocks    []*bstream.OneBlockFile
+		expectFilled []uint64
+		expectErr    bool
+	}{
+		{
+			name: "complete",
+			oneBlocks: []*bstream.OneBlockFile{
+				block100,
+				block101,
+				block102Final100,
+				block103Final101,
+				block104Final102,
+				block105Final103,
+				block106Final104,
+			},
+			expectFilled: []uint64{100, 102},
+		},
+		{
+			name: "missing one-block-files",
+			oneBlocks: []*bstream.OneBlockFile{
+				block100,
+				block101,
+				block102Final100,
+				block103Final101,
+				block104Final102,
+			},
+			expectFilled: []uint64{100},
+			expectErr:    true,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			var merged []uint64
+			io := &TestMergerIO{
+				NextBundleFunc: func(_ context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+					if lowestBaseBlock == 100 {
+						return 100, nil, &HoleError{LowBlockNum: 100, HighBlockNum: 104}
+					}
+					return 106, bstream.NewBlockRef("0000000000000105a", 105), nil
+				},
+				WalkOneBlockFilesFunc: func(_ context.Context, _ uint64, callback func(*bstream.OneBlockFile) error) error {
+					for _, obf := range c.oneBlocks {
+						if err := callback(obf); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+				MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+					merged = append(merged, inclusiveLowerBlock)
+					return nil
+				},
+			}
+
+			m := NewMerger(testLogger, "", io, 100, 2, 100, time.Second, time.Second, 0)
+			defer m.Shutdown(nil)
+
+			summary, err := m.Backfill(context.Background())
+			require.NoError(t, err)
+			require.Len(t, summary.Holes, 1)
+
+			hole := summary.Holes[0]
+			assert.EqualValues(t, 100, hole.LowBlockNum)
+			assert.EqualValues(t, 104, hole.HighBlockNum)
+			assert.Equal(t, c.expectFilled, hole.Filled)
+			assert.Equal(t, c.expectFilled, merged)
+			assert.Equal(t, !c.expectErr, hole.Complete())
+		})
+	}
+}
+
+func TestMergerRunBackfillWaitsForLease(t *testing.T) {
+	LeaseSettleDelay = 0
+	ctx := context.Background()
+	leaseStore, err := dstore.NewStore("file://"+t.TempDir(), "", "", true)
+	require.NoError(t, err)
+
+	active := NewLease(testLogger, leaseStore, "merger.lease", "active", time.Minute)
+	acquired, err := active.TryAcquire(ctx)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	var lookedForHoles int32
+	io := &TestMergerIO{
+		NextBundleFunc: func(_ context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+			atomic.AddInt32(&lookedForHoles, 1)
+			return 100, nil, nil
+		},
+	}
+	lease := NewLease(testLogger, leaseStore, "merger.lease", "backfill", 30*time.Millisecond)
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithLease(lease))
+	go m.RunBackfill()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&lookedForHoles), "lease held by the active merger")
+
+	require.NoError(t, active.Release(ctx))
+	select {
+	case <-m.Terminated():
+	case <-time.After(time.Second):
+		t.Fatal("backfill did not run after the lease was released")
+	}
+	assert.NotZero(t, atomic.LoadInt32(&lookedForHoles))
+}
+
+func TestBoundedIO(t *testing.T) {
+	var merged []uint64
+	io := &boundedIO{
+		IOInterface: &TestMergerIO{
+			MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+				merged = append(merged, inclusiveLowerBlock)
+				return nil
+			},
+		},
+		lowBlockNum:  200,
+		highBlockNum: 400,
+		logger:       testLogger,
+	}
+
+	for _, base := range []uint64{100, 200, 300, 400} {
+		require.NoError(t, io.MergeAndStore(context.Background(), base, nil))
+	}
+	assert.Equal(t, []uint64{200, 300}, merged)
+	assert.Equal(t, []uint64{200, 300}, io.written)
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/streamingfast/dstore"
+)
+
+// BundleIndexExtension is the extension of the index sidecar objects. With an index store opened on the
+// merged blocks store URL, the index of `0000012300.dbin.zst` is written next to it as `0000012300.index.json`
+const BundleIndexExtension = "index.json"
+
+// BundleIndex lists the blocks of a merged file with their position in the decompressed dbin stream. Merged files
+// are compressed by default, so a reader decompresses the object and skips to an offset, it cannot seek in the
+// stored object itself unless the merged store is uncompressed
+type BundleIndex struct {
+	BaseBlockNum uint64              `json:"base_block_num"`
+	Blocks       []*BundleIndexEntry `json:"blocks"`
+}
+
+type BundleIndexEntry struct {
+	Num        uint64     `json:"num"`
+	ID         string     `json:"id"`                  // full block ID, or the truncated one of the filename when the block could not be decoded
+	PreviousID string     `json:"previous_id"`         // full parent ID, same as ID
+	Timestamp  *time.Time `json:"timestamp,omitempty"` // nil when the block could not be decoded
+	Offset     uint64     `json:"offset"`              // offset of the dbin record in the decompressed merged file, header included
+	Length     uint64     `json:"length"`              // length of the dbin record
+	SHA256     string     `json:"sha256"`              // hex checksum of the dbin record
+}
+
+func NewBundleIndexStore(url string) (dstore.Store, error) {
+	store, err := dstore.NewStore(url, BundleIndexExtension, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create bundle index store: %w", err)
+	}
+	return store, nil
+}
+
+func WriteBundleIndex(ctx context.Context, store dstore.Store, index *BundleIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return store.WriteObject(ctx, fileNameForBlocksBundle(index.BaseBlockNum), bytes.NewReader(data))
+}
+
+func ReadBundleIndex(ctx context.Context, store dstore.Store, baseBlockNum uint64) (*BundleIndex, error) {
+	reader, err := store.OpenObject(ctx, fileNameForBlocksBundle(baseBlockNum))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &BundleIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("cannot decode bundle index %s: %w", fileNameForBlocksBundle(baseBlockNum), err)
+	}
+	return index, nil
+}
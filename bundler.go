@@ -20,20 +20,26 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/bstream/forkable"
 	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
 )
 
 var ErrStopBlockReached = errors.New("stop block reached")
+var ErrBundlerReset = errors.New("bundler was reset to a new base block")
+var ErrSubscriptionOverflow = errors.New("subscription buffer full, consumer too slow")
 
 type Bundler struct {
 	sync.Mutex
 
-	io IOInterface
+	io            IOInterface
+	preDownloader *preDownloader
+	logger        *zap.Logger
 
 	baseBlockNum uint64
 
@@ -45,23 +51,73 @@ type Bundler struct {
 	firstStreamableBlock       uint64
 
 	seenBlockFiles     map[string]*bstream.OneBlockFile
+	firstSeen          map[string]time.Time // canonical name -> time the bundler first got the block
 	irreversibleBlocks []*bstream.OneBlockFile
 	forkable           *forkable.Forkable
+
+	subscriptions map[*Subscription]bool
+
+	onBundleMerged []func(baseBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile)
+	onForkedBlocks []func(baseBlockNum uint64, records []*ForkRecord)
+
+	maxReorgDepth              uint64
+	reorgDepthWarningThreshold uint64           // 0 disables the deep reorg warning
+	openForks                  map[string]*Fork // forked block ID -> fork, for the last bundle's forked blocks
+
+	sourceStats *sourceStats
+
+	lastBlockProcessedAt time.Time // zero until the first irreversible block is processed
+	lastMergeError       error     // protected by mergeErrorLock, the bundler lock may be held while waiting on the merge
+	mergeErrorLock       sync.Mutex
+}
+
+// BundlerOption configures an optional feature of a Bundler
+type BundlerOption func(b *Bundler)
+
+// WithOneBlockPreDownload pre-downloads irreversible blocks with `parallelism` workers and at most `window` files in
+// flight, values below 1 keep ParallelOneBlockDownload and OneBlockDownloadWindow
+func WithOneBlockPreDownload(parallelism, window int) BundlerOption {
+	return func(b *Bundler) {
+		b.preDownloader = newPreDownloader(b.logger, b.io, parallelism, window)
+	}
+}
+
+// WithReorgDepthWarningThreshold logs a warning for each fork at least `threshold` deep
+func WithReorgDepthWarningThreshold(threshold uint64) BundlerOption {
+	return func(b *Bundler) {
+		b.reorgDepthWarningThreshold = threshold
+	}
 }
 
-func NewBundler(startBlock, stopBlock, firstStreamableBlock, bundleSize uint64, io IOInterface) *Bundler {
+// NewBundler creates a bundler pre-downloading irreversible blocks in the background, Close() stops the workers
+func NewBundler(logger *zap.Logger, startBlock, stopBlock, firstStreamableBlock, bundleSize uint64, io IOInterface, opts ...BundlerOption) *Bundler {
 	b := &Bundler{
 		bundleSize:           bundleSize,
 		io:                   io,
+		logger:               logger,
 		bundleError:          make(chan error, 1),
 		firstStreamableBlock: firstStreamableBlock,
 		stopBlock:            stopBlock,
 		seenBlockFiles:       make(map[string]*bstream.OneBlockFile),
+		firstSeen:            make(map[string]time.Time),
+		subscriptions:        make(map[*Subscription]bool),
+		sourceStats:          newSourceStats(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.preDownloader == nil {
+		b.preDownloader = newPreDownloader(logger, io, ParallelOneBlockDownload, OneBlockDownloadWindow)
 	}
 	b.Reset(toBaseNum(startBlock, bundleSize), nil)
 	return b
 }
 
+// Close stops the pre-download workers. Blocks handled after that are downloaded when merging.
+func (b *Bundler) Close() {
+	b.preDownloader.Close()
+}
+
 // BaseBlockNum can be called from a different thread
 func (b *Bundler) BaseBlockNum() uint64 {
 	b.inProcess.Lock()
@@ -71,7 +127,31 @@ func (b *Bundler) BaseBlockNum() uint64 {
 }
 
 func (b *Bundler) HandleBlockFile(obf *bstream.OneBlockFile) error {
-	b.seenBlockFiles[obf.CanonicalName] = obf
+	if seen, ok := b.seenBlockFiles[obf.CanonicalName]; ok {
+		// same block from another source: forkable keeps the first object, which may be downloading already,
+		// so every source's filename goes into that one
+		seen.Lock()
+		known := true
+		for filename := range obf.Filenames {
+			if !seen.Filenames[filename] {
+				known = false
+				seen.Filenames[filename] = true
+			}
+		}
+		seen.Unlock()
+		if known {
+			return nil // same file again, from the watcher and a listing, or from a full walk
+		}
+		obf = seen
+	} else {
+		if _, ok := b.firstSeen[obf.CanonicalName]; !ok {
+			b.firstSeen[obf.CanonicalName] = time.Now()
+		}
+		b.seenBlockFiles[obf.CanonicalName] = obf
+	}
+	if obf.Num >= b.baseBlockNum { // files of merged blocks are seen again until they are pruned
+		b.sourceStats.fileSeen(obf, time.Now())
+	}
 	return b.forkable.ProcessBlock(obf.ToBstreamBlock(), obf) // forkable will call our own b.ProcessBlock() on irreversible blocks only
 }
 
@@ -81,6 +161,7 @@ func (b *Bundler) forkedBlocksInCurrentBundle() (out []*bstream.OneBlockFile) {
 	// remove irreversible blocks from map (they will be merged and deleted soon)
 	for _, block := range b.irreversibleBlocks {
 		delete(b.seenBlockFiles, block.CanonicalName)
+		delete(b.firstSeen, block.CanonicalName)
 	}
 
 	// identify and then delete remaining blocks from map, return them as forks
@@ -96,6 +177,35 @@ func (b *Bundler) forkedBlocksInCurrentBundle() (out []*bstream.OneBlockFile) {
 	return
 }
 
+// forkRecords describes the forked blocks of a bundle, along with the irreversible block found at their height
+func (b *Bundler) forkRecords(baseBlockNum uint64, forkedBlocks, irreversibleBlocks []*bstream.OneBlockFile) []*ForkRecord {
+	canonicalIDs := make(map[uint64]string, len(irreversibleBlocks))
+	for _, block := range irreversibleBlocks {
+		canonicalIDs[block.Num] = block.ID
+	}
+
+	out := make([]*ForkRecord, 0, len(forkedBlocks))
+	for _, block := range forkedBlocks {
+		out = append(out, &ForkRecord{
+			BundleBaseBlockNum: baseBlockNum,
+			BlockNum:           block.Num,
+			ForkedID:           block.ID,
+			ForkedPreviousID:   block.PreviousID,
+			CanonicalID:        canonicalIDs[block.Num],
+			Sources:            oneBlockFileSources(block),
+			DiscoveredAt:       b.firstSeen[block.CanonicalName],
+		})
+		delete(b.firstSeen, block.CanonicalName)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].BlockNum == out[j].BlockNum {
+			return out[i].ForkedID < out[j].ForkedID
+		}
+		return out[i].BlockNum < out[j].BlockNum
+	})
+	return out
+}
+
 func (b *Bundler) Reset(nextBase uint64, lib bstream.BlockRef) {
 	options := []forkable.Option{
 		forkable.WithFilters(bstream.StepIrreversible),
@@ -109,22 +219,116 @@ func (b *Bundler) Reset(nextBase uint64, lib bstream.BlockRef) {
 		b.enforceNextBlockOnBoundary = true
 	}
 	b.forkable = forkable.New(b, options...)
+	b.sourceStats.reset()
 
 	b.Lock()
 	b.baseBlockNum = nextBase
 	b.irreversibleBlocks = nil
+	b.openForks = nil
+	// subscribers cannot follow a jump in the bundler, they need to resubscribe
+	for sub := range b.subscriptions {
+		sub.close(ErrBundlerReset)
+		delete(b.subscriptions, sub)
+	}
 	b.Unlock()
 }
 
-func readBlockTime(data []byte) (time.Time, error) {
+// OnBundleMerged registers a function called after each successful MergeAndStore, with the same arguments
+func (b *Bundler) OnBundleMerged(f func(baseBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile)) {
+	b.onBundleMerged = append(b.onBundleMerged, f)
+}
+
+// OnForkedBlocks registers a function called after each successful MergeAndStore of a bundle that had forked blocks
+func (b *Bundler) OnForkedBlocks(f func(baseBlockNum uint64, records []*ForkRecord)) {
+	b.onForkedBlocks = append(b.onForkedBlocks, f)
+}
+
+func (b *Bundler) bundleMerged(baseBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+	for _, f := range b.onBundleMerged {
+		f(baseBlockNum, oneBlockFiles)
+	}
+}
+
+// Subscribe can be called from a different thread. It returns a copy of the irreversible blocks
+// accumulated in the bundler (not merged yet), along with a subscription that will receive
+// every irreversible block appended after that.
+func (b *Bundler) Subscribe(bufferSize int) ([]*bstream.OneBlockFile, *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	sub := &Subscription{
+		blocks: make(chan *bstream.OneBlockFile, bufferSize),
+	}
+	b.subscriptions[sub] = true
+
+	blocks := make([]*bstream.OneBlockFile, len(b.irreversibleBlocks))
+	copy(blocks, b.irreversibleBlocks)
+	return blocks, sub
+}
+
+// Unsubscribe can be called from a different thread
+func (b *Bundler) Unsubscribe(sub *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subscriptions[sub] {
+		sub.close(nil)
+		delete(b.subscriptions, sub)
+	}
+}
+
+// publish must be called while holding the bundler lock
+func (b *Bundler) publish(obf *bstream.OneBlockFile) {
+	for sub := range b.subscriptions {
+		select {
+		case sub.blocks <- obf:
+		default:
+			sub.close(ErrSubscriptionOverflow)
+			delete(b.subscriptions, sub)
+		}
+	}
+}
+
+// Subscription receives irreversible blocks from the bundler, in order, until it is closed
+type Subscription struct {
+	blocks chan *bstream.OneBlockFile
+	err    error
+}
+
+// Blocks is closed when the subscription ends, Err() then tells why
+func (s *Subscription) Blocks() <-chan *bstream.OneBlockFile {
+	return s.blocks
+}
+
+// Err is only valid once the Blocks channel is closed
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+func (s *Subscription) close(err error) {
+	s.err = err
+	close(s.blocks)
+}
+
+func readBlock(data []byte) (*bstream.Block, error) {
 	reader := bytes.NewReader(data)
 	blockReader, err := bstream.GetBlockReaderFactory.New(reader)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("unable to create block reader: %w", err)
+		return nil, fmt.Errorf("unable to create block reader: %w", err)
 	}
 	blk, err := blockReader.Read()
 	if err != nil && err != io.EOF {
-		return time.Time{}, fmt.Errorf("block reader failed: %w", err)
+		return nil, fmt.Errorf("block reader failed: %w", err)
+	}
+	if blk == nil {
+		return nil, fmt.Errorf("block reader returned no block")
+	}
+	return blk, nil
+}
+
+func readBlockTime(data []byte) (time.Time, error) {
+	blk, err := readBlock(data)
+	if err != nil {
+		return time.Time{}, err
 	}
 	return blk.Time(), nil
 }
@@ -145,21 +349,13 @@ func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 
 	if obf.Num < b.baseBlockNum+b.bundleSize {
 		b.Lock()
-		metrics.AppReadiness.SetReady()
+		b.lastBlockProcessedAt = time.Now()
 		b.irreversibleBlocks = append(b.irreversibleBlocks, obf)
+		b.publish(obf)
 		metrics.HeadBlockNumber.SetUint64(obf.Num)
-		go func() {
-			// this pre-downloads the data
-			data, err := obf.Data(context.Background(), b.io.DownloadOneBlockFile)
-			if err != nil {
-				return
-			}
-			// now that we have the data, might as well read the block time for metrics
-			if time, err := readBlockTime(data); err == nil {
-				metrics.HeadBlockTimeDrift.SetBlockTime(time)
-			}
-		}()
 		b.Unlock()
+
+		b.preDownloader.Download(obf) // blocks when too many downloads are in flight, slowing down the walk
 		return nil
 	}
 
@@ -170,36 +366,61 @@ func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 	}
 
 	forkedBlocks := b.forkedBlocksInCurrentBundle()
+	metrics.ForkedBlocks.AddInt(len(forkedBlocks))
+	metrics.LastBundleForkedBlocks.SetUint64(uint64(len(forkedBlocks)))
 	blocksToBundle := b.irreversibleBlocks
 	baseBlockNum := b.baseBlockNum
+	forkRecords := b.forkRecords(baseBlockNum, forkedBlocks, blocksToBundle)
+	b.recordForks(forkedBlocks, blocksToBundle)
+	b.sourceStats.bundled(baseBlockNum, baseBlockNum+b.bundleSize, blocksToBundle, forkedBlocks)
 	b.inProcess.Lock()
 	go func() {
 		defer b.inProcess.Unlock()
-		if err := b.io.MergeAndStore(context.Background(), baseBlockNum, blocksToBundle); err != nil {
+		err := b.io.MergeAndStore(context.Background(), baseBlockNum, blocksToBundle)
+		b.mergeErrorLock.Lock()
+		b.lastMergeError = err
+		b.mergeErrorLock.Unlock()
+		if err != nil {
 			b.bundleError <- err
 			return
 		}
+		b.bundleMerged(baseBlockNum, blocksToBundle)
 		if forkableIO, ok := b.io.(ForkAwareIOInterface); ok {
 			forkableIO.MoveForkedBlocks(context.Background(), forkedBlocks)
 		}
+		if len(forkRecords) != 0 {
+			for _, f := range b.onForkedBlocks {
+				f(baseBlockNum, forkRecords)
+			}
+		}
 		// we do not delete bundled blocks here, they get pruned later. keeping the blocks from the last bundle is useful for bootstrapping
 	}()
 
 	b.Lock()
+	b.lastBlockProcessedAt = time.Now()
 	// we keep the last block of the bundle, only deleting it on next merge, to facilitate joining to one-block-filled hub
 	lastBlock := b.irreversibleBlocks[len(b.irreversibleBlocks)-1]
 	b.irreversibleBlocks = []*bstream.OneBlockFile{lastBlock, obf}
+	b.publish(obf)
 	b.baseBlockNum += b.bundleSize
 	for obf.Num > b.baseBlockNum+b.bundleSize { // skip more merged-block-files
 		b.inProcess.Lock()
 		if err := b.io.MergeAndStore(context.Background(), b.baseBlockNum, []*bstream.OneBlockFile{lastBlock}); err != nil { // lastBlock will be excluded from bundle but is useful to bundler
+			b.mergeErrorLock.Lock()
+			b.lastMergeError = err
+			b.mergeErrorLock.Unlock()
+			b.inProcess.Unlock()
+			b.Unlock()
 			return err
 		}
+		b.bundleMerged(b.baseBlockNum, []*bstream.OneBlockFile{lastBlock})
 		b.inProcess.Unlock()
 		b.baseBlockNum += b.bundleSize
 	}
 	b.Unlock()
 
+	b.preDownloader.Download(obf) // first block of the new bundle
+
 	if b.stopBlock != 0 && b.baseBlockNum >= b.stopBlock {
 		return ErrStopBlockReached
 	}
@@ -207,6 +428,37 @@ func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 	return nil
 }
 
+// SourceStats can be called from a different thread, it returns the statistics of each source of one-block-files
+func (b *Bundler) SourceStats() []*SourceStats {
+	return b.sourceStats.snapshot()
+}
+
+// Health can be called from a different thread. It returns an error describing why the bundler is not healthy.
+// A zero headStallThreshold disables the check on the time since the last processed block.
+func (b *Bundler) Health(headStallThreshold time.Duration) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.lastBlockProcessedAt.IsZero() {
+		return fmt.Errorf("no block processed yet")
+	}
+	b.mergeErrorLock.Lock()
+	lastMergeError := b.lastMergeError
+	b.mergeErrorLock.Unlock()
+	if lastMergeError != nil {
+		return fmt.Errorf("last merge failed: %w", lastMergeError)
+	}
+	if len(b.bundleError) != 0 {
+		return fmt.Errorf("bundle error pending")
+	}
+	if headStallThreshold != 0 {
+		if since := time.Since(b.lastBlockProcessedAt); since > headStallThreshold {
+			return fmt.Errorf("head stalled, no block processed for %s", since)
+		}
+	}
+	return nil
+}
+
 // String can be called from a different thread
 func (b *Bundler) String() string {
 	b.Lock()
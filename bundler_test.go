@@ -5,7 +5,10 @@ import (
 	//"fmt"
 
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	//	"time"
 
@@ -30,12 +33,9 @@ var block507Final106 = bstream.MustNewOneBlockFile("0000000507-0000000000000507a
 var block608Final507 = bstream.MustNewOneBlockFile("0000000608-0000000000000608a-0000000000000507a-507-suffix")
 var block609Final608 = bstream.MustNewOneBlockFile("0000000609-0000000000000607a-0000000000000608a-608-suffix")
 
-func init() {
-	bstream.GetBlockReaderFactory = bstream.TestBlockReaderFactory
-}
-
 func TestNewBundler(t *testing.T) {
-	b := NewBundler(100, 200, 2, 100, nil)
+	b := NewBundler(testLogger, 100, 200, 2, 100, nil)
+	defer b.Close()
 	require.NotNil(t, b)
 	assert.EqualValues(t, 100, b.bundleSize)
 	assert.EqualValues(t, 200, b.stopBlock)
@@ -44,7 +44,8 @@ func TestNewBundler(t *testing.T) {
 }
 
 func TestBundlerReset(t *testing.T) {
-	b := NewBundler(100, 200, 2, 2, nil) // merge every 2 blocks
+	b := NewBundler(testLogger, 100, 200, 2, 2, nil) // merge every 2 blocks
+	defer b.Close()
 
 	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101}
 	b.Reset(102, block100.ToBstreamBlock().AsRef())
@@ -146,12 +147,13 @@ func TestBundlerMergeKeepOne(t *testing.T) {
 
 		t.Run(c.name, func(t *testing.T) {
 			var merged []uint64
-			b := NewBundler(100, 700, 2, c.mergeSize, &TestMergerIO{
+			b := NewBundler(testLogger, 100, 700, 2, c.mergeSize, &TestMergerIO{
 				MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) (err error) {
 					merged = append(merged, inclusiveLowerBlock)
 					return nil
 				},
 			}) // merge every 2 blocks
+			defer b.Close()
 			b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101}
 
 			for _, blk := range c.inBlocks {
@@ -168,3 +170,163 @@ func TestBundlerMergeKeepOne(t *testing.T) {
 		})
 	}
 }
+
+func TestBundlerSubscribe(t *testing.T) {
+	b := NewBundler(testLogger, 100, 700, 2, 100, &TestMergerIO{})
+	defer b.Close()
+
+	blocks, sub := b.Subscribe(10)
+	assert.Len(t, blocks, 0)
+
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101} {
+		require.NoError(t, b.HandleBlockFile(blk))
+	}
+	assert.Equal(t, block100, <-sub.Blocks())
+	assert.Equal(t, block101, <-sub.Blocks())
+
+	blocks, lateSub := b.Subscribe(10)
+	assert.Equal(t, []*bstream.OneBlockFile{block100, block101}, blocks)
+
+	b.Reset(200, nil)
+	for _, s := range []*Subscription{sub, lateSub} {
+		_, ok := <-s.Blocks()
+		assert.False(t, ok)
+		assert.Equal(t, ErrBundlerReset, s.Err())
+		b.Unsubscribe(s) // already closed by Reset
+	}
+}
+
+func TestBundlerSubscribeOverflow(t *testing.T) {
+	b := NewBundler(testLogger, 100, 700, 2, 100, &TestMergerIO{})
+	defer b.Close()
+	_, sub := b.Subscribe(1)
+
+	b.Lock()
+	b.publish(block100)
+	b.publish(block101)
+	b.Unlock()
+
+	assert.Equal(t, block100, <-sub.Blocks())
+	_, ok := <-sub.Blocks()
+	assert.False(t, ok)
+	assert.Equal(t, ErrSubscriptionOverflow, sub.Err())
+}
+
+func TestBundlerForkRecords(t *testing.T) {
+	var records []*ForkRecord
+	b := NewBundler(testLogger, 100, 700, 2, 2, &TestMergerIO{})
+	defer b.Close()
+	b.OnForkedBlocks(func(baseBlockNum uint64, r []*ForkRecord) {
+		assert.EqualValues(t, 100, baseBlockNum)
+		records = r
+	})
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101}
+
+	block101b := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-source1")
+	block101bOther := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-source2")
+	for _, blk := range []*bstream.OneBlockFile{block100, block101b, block101bOther, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(blk))
+	}
+
+	// wait for MergeAndStore
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+
+	require.Len(t, records, 1)
+	assert.EqualValues(t, 101, records[0].BlockNum)
+	assert.Equal(t, "0000000000000101b", records[0].ForkedID)
+	assert.Equal(t, "0000000000000101a", records[0].CanonicalID)
+	assert.Equal(t, []string{"source1", "source2"}, records[0].Sources)
+	assert.False(t, records[0].DiscoveredAt.IsZero())
+}
+
+func TestBundlerIrreversibleBlockKeepsAllSources(t *testing.T) {
+	b := NewBundler(testLogger, 100, 700, 2, 100, &TestMergerIO{})
+	defer b.Close()
+
+	for _, name := range []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-source1",
+		"0000000100-0000000000000100a-0000000000000099a-98-source2",
+		"0000000100-0000000000000100a-0000000000000099a-98-source3",
+		"0000000101-0000000000000101a-0000000000000100a-99-source1",
+		"0000000102-0000000000000102a-0000000000000101a-100-source1",
+	} {
+		require.NoError(t, b.HandleBlockFile(bstream.MustNewOneBlockFile(name)))
+	}
+
+	require.Len(t, b.irreversibleBlocks, 1)
+	assert.Equal(t, map[string]bool{
+		"0000000100-0000000000000100a-0000000000000099a-98-source1": true,
+		"0000000100-0000000000000100a-0000000000000099a-98-source2": true,
+		"0000000100-0000000000000100a-0000000000000099a-98-source3": true,
+	}, b.irreversibleBlocks[0].Filenames)
+}
+
+func TestBundlerSkipMergeError(t *testing.T) {
+	b := NewBundler(testLogger, 100, 700, 2, 100, &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) (err error) {
+			if inclusiveLowerBlock == 200 {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		},
+	})
+	defer b.Close()
+
+	var err error
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104, block507Final106, block608Final507} {
+		if err = b.HandleBlockFile(blk); err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+
+	done := make(chan error)
+	go func() { done <- b.Health(0) }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("bundler lock still held after failed merge")
+	}
+}
+
+func TestBundlerPreDownloadsNewBundleFirstBlock(t *testing.T) {
+	var lock sync.Mutex
+	downloaded := map[uint64]bool{}
+	b := NewBundler(testLogger, 100, 700, 2, 2, &TestMergerIO{
+		DownloadOneBlockFileFunc: func(_ context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			downloaded[obf.Num] = true
+			return nil, fmt.Errorf("no data")
+		},
+	})
+	defer b.Close()
+
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(blk))
+	}
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return downloaded[100] && downloaded[101] && downloaded[102]
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBundlerHandlesFileOnce(t *testing.T) {
+	b := NewBundler(testLogger, 100, 700, 2, 100, &TestMergerIO{})
+	defer b.Close()
+
+	name := "0000000100-0000000000000100a-0000000000000099a-98-source1"
+	first := bstream.MustNewOneBlockFile(name)
+	require.NoError(t, b.HandleBlockFile(first))
+	require.NoError(t, b.HandleBlockFile(bstream.MustNewOneBlockFile(name)), "from the watcher, then from a listing")
+	assert.Same(t, first, b.seenBlockFiles[first.CanonicalName], "second one ignored")
+
+	other := bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-source2")
+	require.NoError(t, b.HandleBlockFile(other))
+	assert.Same(t, first, b.seenBlockFiles[first.CanonicalName], "new source merged into the first object")
+	assert.Len(t, first.Filenames, 2)
+}
@@ -16,6 +16,8 @@ package merger
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 
@@ -31,6 +33,11 @@ type BundleReader struct {
 	oneBlockDataChan chan []byte
 	errChan          chan error
 
+	oneBlockFiles []*bstream.OneBlockFile
+	indexing      bool
+	offset        uint64
+	index         []*BundleIndexEntry
+
 	logger *zap.Logger
 }
 
@@ -40,6 +47,7 @@ func NewBundleReader(ctx context.Context, logger *zap.Logger, tracer logging.Tra
 		logger:           logger,
 		oneBlockDataChan: make(chan []byte, 1),
 		errChan:          make(chan error, 1),
+		oneBlockFiles:    oneBlockFiles,
 	}
 
 	data, err := anyOneBlockFile.Data(ctx, oneBlockDownloader)
@@ -50,6 +58,7 @@ func NewBundleReader(ctx context.Context, logger *zap.Logger, tracer logging.Tra
 		return nil, fmt.Errorf("one-block-file corrupt: expected header size of %d, but file size is only %d bytes", bstream.GetBlockWriterHeaderLen, len(data))
 	}
 	r.readBuffer = data[:bstream.GetBlockWriterHeaderLen]
+	r.offset = uint64(bstream.GetBlockWriterHeaderLen)
 
 	go r.downloadAll(oneBlockFiles, oneBlockDownloader)
 
@@ -108,8 +117,45 @@ func (r *BundleReader) fillBuffer() error {
 	if len(data) < bstream.GetBlockWriterHeaderLen {
 		return fmt.Errorf("one-block-file corrupt: expected header size of %d, but file size is only %d bytes", bstream.GetBlockWriterHeaderLen, len(data))
 	}
-	data = data[bstream.GetBlockWriterHeaderLen:]
-	r.readBuffer = data
+	if r.indexing {
+		r.addIndexEntry(data)
+	}
+	payload := data[bstream.GetBlockWriterHeaderLen:]
+	r.offset += uint64(len(payload))
+	r.readBuffer = payload
 	r.readBufferOffset = 0
 	return nil
 }
+
+// EnableIndex makes the reader record a BundleIndexEntry for each block it reads, it must be called before the first Read
+func (r *BundleReader) EnableIndex() {
+	r.indexing = true
+}
+
+// Index returns the entries of the blocks read so far, it is complete once Read returned io.EOF
+func (r *BundleReader) Index() []*BundleIndexEntry {
+	return r.index
+}
+
+func (r *BundleReader) addIndexEntry(data []byte) {
+	obf := r.oneBlockFiles[len(r.index)]
+	payload := data[bstream.GetBlockWriterHeaderLen:]
+	checksum := sha256.Sum256(payload)
+
+	entry := &BundleIndexEntry{
+		Num:        obf.Num,
+		ID:         obf.ID,
+		PreviousID: obf.PreviousID,
+		Offset:     r.offset,
+		Length:     uint64(len(payload)),
+		SHA256:     hex.EncodeToString(checksum[:]),
+	}
+	// the IDs of the filename are truncated, the block has the full ones
+	if blk, err := readBlock(data); err == nil {
+		entry.ID = blk.Id
+		entry.PreviousID = blk.PreviousId
+		blockTime := blk.Time()
+		entry.Timestamp = &blockTime
+	}
+	r.index = append(r.index, entry)
+}
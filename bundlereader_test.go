@@ -3,10 +3,13 @@ package merger
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -134,6 +137,43 @@ func TestBundleReader_Read_Then_Read_Block(t *testing.T) {
 	require.Equal(t, b3, bundle[2].MemoizeData[14:])
 }
 
+func TestBundleReader_Index(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	var bundle []*bstream.OneBlockFile
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100} {
+		bundle = append(bundle, &bstream.OneBlockFile{
+			CanonicalName: obf.CanonicalName,
+			Num:           obf.Num,
+			ID:            obf.ID,
+			PreviousID:    obf.PreviousID,
+			MemoizeData:   []byte(fmt.Sprintf(`{"id":%q,"prev":%q,"num":%d,"time":"2021-01-01T00:00:%02d.000"}`+"\n", fullTestBlockID(obf.ID), fullTestBlockID(obf.PreviousID), obf.Num, obf.Num-100)),
+		})
+	}
+
+	r, err := NewBundleReader(context.Background(), testLogger, testTracer, bundle, bundle[0], nil)
+	require.NoError(t, err)
+	r.EnableIndex()
+	allBlockData, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	index := r.Index()
+	require.Len(t, index, 3)
+	for i, entry := range index {
+		assert.Equal(t, bundle[i].Num, entry.Num)
+		assert.Equal(t, fullTestBlockID(bundle[i].ID), entry.ID)
+		assert.Equal(t, fullTestBlockID(bundle[i].PreviousID), entry.PreviousID)
+		require.NotNil(t, entry.Timestamp)
+		assert.Equal(t, i, entry.Timestamp.Second())
+
+		record := allBlockData[entry.Offset : entry.Offset+entry.Length]
+		assert.Equal(t, bundle[i].MemoizeData, record)
+		checksum := sha256.Sum256(record)
+		assert.Equal(t, hex.EncodeToString(checksum[:]), entry.SHA256)
+	}
+	assert.EqualValues(t, len(allBlockData), index[2].Offset+index[2].Length)
+}
+
 func TestBundleReader_Read_DownloadOneBlockFileError(t *testing.T) {
 	bundle := NewDownloadBundle()
 	bstream.GetBlockWriterHeaderLen = 0
@@ -295,3 +335,8 @@ func NewDownloadBundle() []*bstream.OneBlockFile {
 	}
 	return []*bstream.OneBlockFile{o1, o2, o3}
 }
+
+// fullTestBlockID is a 64 characters block ID, of which one-block-file names only keep a prefix
+func fullTestBlockID(truncated string) string {
+	return truncated + strings.Repeat("f", 64-len(truncated))
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/streamingfast/merger"
+)
+
+func explodeCmd(args []string) error {
+	var stores storeFlags
+	var sourceID string
+	fs := flag.NewFlagSet("explode", flag.ContinueOnError)
+	stores.register(fs, true)
+	fs.StringVar(&sourceID, "source-id", merger.DefaultExplodeSourceID, "source written in the name of the one-block-files, it cannot contain '-'")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: merger explode [flags] <base-block-num>\n\nthe merger only merges one-block-files above its last merged file: delete a bad merged file before exploding a good copy of it, then run `merger run -backfill-holes`\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	nums, err := parseBlockNums(fs.Args(), "base-block-num")
+	if err != nil {
+		return err
+	}
+
+	io, err := stores.newIO(true)
+	if err != nil {
+		return err
+	}
+	dio, err := dstoreIO(io)
+	if err != nil {
+		return err
+	}
+
+	filenames, err := dio.ExplodeBundle(context.Background(), nums[0], sourceID)
+	for _, filename := range filenames {
+		fmt.Println(filename)
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger"
+)
+
+func holesCmd(args []string) error {
+	var stores storeFlags
+	var lowBlockNum uint64
+	fs := flag.NewFlagSet("holes", flag.ContinueOnError)
+	stores.register(fs, false)
+	fs.Uint64Var(&lowBlockNum, "low-block-num", bstream.GetProtocolFirstStreamableBlock, "block where the search starts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	io, err := stores.newIO(false)
+	if err != nil {
+		return err
+	}
+
+	holes, head, err := merger.FindHoles(context.Background(), io, lowBlockNum-lowBlockNum%stores.bundleSize)
+	if err != nil {
+		return err
+	}
+
+	for _, hole := range holes {
+		fmt.Printf("%d-%d (%d bundles)\n", hole.LowBlockNum, hole.HighBlockNum, (hole.HighBlockNum-hole.LowBlockNum)/stores.bundleSize)
+	}
+	fmt.Printf("%d holes, next merged file to write is %d\n", len(holes), head)
+	return nil
+}
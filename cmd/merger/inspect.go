@@ -0,0 +1,66 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func inspectCmd(args []string) error {
+	var stores storeFlags
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	stores.register(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: merger inspect [flags] <base-block-num>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	nums, err := parseBlockNums(fs.Args(), "base-block-num")
+	if err != nil {
+		return err
+	}
+
+	io, err := stores.newIO(false)
+	if err != nil {
+		return err
+	}
+	dio, err := dstoreIO(io)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := dio.ReadBundle(context.Background(), nums[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NUM\tID\tPREVIOUS ID\tLIB\tTIMESTAMP")
+	for _, block := range blocks {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", block.Number, block.Id, block.PreviousId, block.LibNum, block.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d blocks\n", len(blocks))
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command merger runs the merger, or one of its maintenance operations, against dstore URLs.
+//
+//	merger run -one-block-store=... -merged-store=... [flags]
+//	merger inspect -merged-store=... <base-block-num>
+//	merger verify -merged-store=... <low-block-num> <high-block-num>
+//	merger holes -merged-store=...
+//	merger prune -one-block-store=... -merged-store=... [-forked-store=...]
+//	merger explode -merged-store=... -one-block-store=... [-source-id=...] <base-block-num>
+//	merger rebundle -merged-store=... -bundle-size=100 -dest-store=... -dest-bundle-size=1000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+var zlog, tracer = logging.ApplicationLogger("merger", "github.com/streamingfast/merger/cmd/merger")
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{"run", "run the merger", runCmd},
+	{"inspect", "list the blocks of a merged file", inspectCmd},
+	{"verify", "check the merged files of a block range", verifyCmd},
+	{"holes", "list the missing merged files", holesCmd},
+	{"prune", "delete the one-block-files and forked blocks that were merged, once", pruneCmd},
+	{"explode", "write the blocks of a merged file back as one-block-files", explodeCmd},
+	{"rebundle", "copy merged files to another store with a different bundle size", rebundleCmd},
+}
+
+func main() {
+	setupBlockRegistry()
+
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		if err := cmd.run(os.Args[2:]); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(2)
+			}
+			zlog.Debug("command failed", zap.String("command", cmd.name), zap.Error(err))
+			fmt.Fprintf(os.Stderr, "merger %s: %s\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintf(out, "usage: merger <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(out, "\nrun `merger <command> -h` for the flags of a command\n")
+}
+
+// setupBlockRegistry reads blocks as generic bstream blocks, leaving their payload undecoded, so this binary
+// works with the files of any chain. The merger never needs to look into the payload.
+func setupBlockRegistry() {
+	if bstream.GetBlockReaderFactory == nil {
+		bstream.GetBlockReaderFactory = bstream.BlockReaderFactoryFunc(func(reader io.Reader) (bstream.BlockReader, error) {
+			return bstream.NewDBinBlockReader(reader, nil)
+		})
+	}
+	if bstream.GetBlockPayloadSetter == nil {
+		bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+	}
+	if bstream.GetBlockWriterHeaderLen == 0 {
+		bstream.GetBlockWriterHeaderLen = 10 // dbin header
+	}
+}
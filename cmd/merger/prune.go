@@ -0,0 +1,88 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger"
+)
+
+func pruneCmd(args []string) error {
+	var stores storeFlags
+	var pruneForkedBlocksAfter uint64
+	var beforeBlockNum uint64
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	stores.register(fs, true)
+	fs.Uint64Var(&pruneForkedBlocksAfter, "prune-forked-blocks-after", 50000, "number of blocks below the head of the merged files where forked blocks are deleted")
+	fs.Uint64Var(&beforeBlockNum, "before-block-num", 0, "delete the one-block-files below this block, defaults to one bundle below the head of the merged files or below the first hole, which backfilling needs (it can be above a hole)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	io, err := stores.newIO(true)
+	if err != nil {
+		return err
+	}
+	dio, err := dstoreIO(io)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	firstBase := bstream.GetProtocolFirstStreamableBlock - bstream.GetProtocolFirstStreamableBlock%stores.bundleSize
+	holes, head, err := merger.FindHoles(ctx, io, firstBase)
+	if err != nil {
+		return err
+	}
+
+	// same targets as the pruners of a running merger, whose bundler would be at the head, but the one-block-files
+	// of the holes are kept for backfilling
+	target := beforeBlockNum
+	if target == 0 {
+		keepFrom := head
+		if len(holes) != 0 {
+			keepFrom = holes[0].LowBlockNum
+			fmt.Printf("keeping the one-block-files from the first hole at block %d, set -before-block-num to delete them\n", keepFrom)
+		}
+		if keepFrom >= stores.bundleSize {
+			target = keepFrom - stores.bundleSize
+		}
+	}
+	if target > head {
+		return fmt.Errorf("refusing to delete one-block-files above the head of the merged files (%d)", head)
+	}
+
+	if target != 0 {
+		deleted, err := dio.PruneOneBlockFiles(ctx, bstream.GetProtocolFirstStreamableBlock, target)
+		fmt.Printf("deleted %d one-block-files below block %d\n", deleted, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	if forkAware, ok := io.(*merger.ForkAwareDStoreIO); ok && head >= pruneForkedBlocksAfter {
+		forkedTarget := head - pruneForkedBlocksAfter
+		deleted, err := forkAware.PruneForkedBlocks(ctx, bstream.GetProtocolFirstStreamableBlock, forkedTarget)
+		fmt.Printf("deleted %d forked blocks files up to block %d\n", deleted, forkedTarget)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/merger"
+	appmerger "github.com/streamingfast/merger/app/merger"
+)
+
+func rebundleCmd(args []string) error {
+	var stores storeFlags
+	var destStore, destCompression string
+	var destBundleSize, lowBlockNum, highBlockNum uint64
+	fs := flag.NewFlagSet("rebundle", flag.ContinueOnError)
+	stores.register(fs, false)
+	fs.StringVar(&destStore, "dest-store", "", "dstore URL where the merged files are written with the new bundle size")
+	fs.StringVar(&destCompression, "dest-compression", "", "compression of the written merged files: zstd, gzip or none, with an optional level (ex: zstd:19)")
+	fs.Uint64Var(&destBundleSize, "dest-bundle-size", 0, "number of blocks in each written merged file")
+	fs.Uint64Var(&lowBlockNum, "low-block-num", 0, "first block to rebundle")
+	fs.Uint64Var(&highBlockNum, "high-block-num", 0, "block where rebundling stops, exclusive (0 goes on until the first missing merged file)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: merger rebundle [flags]\n\nmerged files already in the destination store are skipped, so an interrupted run can be resumed\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if stores.mergedStore == "" || destStore == "" {
+		return fmt.Errorf("-merged-store and -dest-store are required")
+	}
+	if stores.bundleSize == 0 || destBundleSize == 0 {
+		return fmt.Errorf("-bundle-size and -dest-bundle-size cannot be 0")
+	}
+
+	src, err := appmerger.NewDBinStore(stores.mergedStore, stores.mergedCompression)
+	if err != nil {
+		return fmt.Errorf("opening merged blocks store: %w", err)
+	}
+	dst, err := appmerger.NewDBinStore(destStore, destCompression)
+	if err != nil {
+		return fmt.Errorf("opening destination store: %w", err)
+	}
+
+	rebundler := merger.NewRebundler(zlog, tracer, src, stores.bundleSize, dst, destBundleSize, 5, 500*time.Millisecond)
+	summary, err := rebundler.Rebundle(context.Background(), lowBlockNum, highBlockNum)
+	if summary != nil {
+		fmt.Printf("wrote %d merged files (%d blocks), skipped %d existing ones, next merged file to write is %d\n", len(summary.Written), summary.Blocks, len(summary.Skipped), summary.NextBundle)
+	}
+	return err
+}
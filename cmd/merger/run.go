@@ -0,0 +1,94 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/streamingfast/dmetrics"
+	appmerger "github.com/streamingfast/merger/app/merger"
+	"go.uber.org/zap"
+)
+
+func runCmd(args []string) error {
+	config := &appmerger.Config{}
+	var metricsListenAddr string
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.StringVar(&config.StorageOneBlockFilesPath, "one-block-store", "", "dstore URL of the one-block-files")
+	fs.StringVar(&config.StorageMergedBlocksFilesPath, "merged-store", "", "dstore URL of the merged files")
+	fs.StringVar(&config.StorageForkedBlocksFilesPath, "forked-store", "", "dstore URL where forked blocks are moved (optional)")
+	fs.StringVar(&config.OneBlockFilesCompression, "one-block-compression", "", "compression of the one-block-files written by the merger: zstd, gzip or none, with an optional level (ex: zstd:19)")
+	fs.StringVar(&config.MergedBlocksFilesCompression, "merged-compression", "", "compression of the merged files")
+	fs.StringVar(&config.ForkedBlocksFilesCompression, "forked-compression", "", "compression of the forked blocks files")
+	fs.StringVar(&config.GRPCListenAddr, "grpc-listen-addr", ":9001", "address of the merger gRPC server")
+	fs.StringVar(&config.StatusListenAddr, "status-listen-addr", "", "address of the HTTP status API, ex: GET /status/sources (optional)")
+	fs.StringVar(&metricsListenAddr, "metrics-listen-addr", "", "address where prometheus metrics are served (optional)")
+	fs.Uint64Var(&config.PruneForkedBlocksAfter, "prune-forked-blocks-after", 50000, "number of blocks below the LIB where forked blocks are deleted")
+	fs.Uint64Var(&config.BundleSize, "bundle-size", appmerger.DefaultBundleSize, "number of blocks in each merged file")
+	fs.DurationVar(&config.TimeBetweenPruning, "time-between-pruning", time.Minute, "delay between two pruning passes")
+	fs.DurationVar(&config.TimeBetweenPolling, "time-between-polling", time.Second, "delay between two listings of the one-block-files store")
+	fs.Uint64Var(&config.StopBlock, "stop-block", 0, "block where the merger stops (0 runs forever)")
+	fs.IntVar(&config.OneBlockDownloadParallelism, "one-block-download-parallelism", 0, "number of one-block-files downloaded in parallel (0 keeps the default)")
+	fs.IntVar(&config.OneBlockDownloadWindow, "one-block-download-window", 0, "maximum number of one-block-files downloaded ahead (0 keeps the default)")
+	fs.Uint64Var(&config.ReorgDepthWarningThreshold, "reorg-depth-warning-threshold", 0, "fork depth from which a warning is logged (0 disables it)")
+	fs.StringVar(&config.StorageForkArchivesPath, "fork-archive-store", "", "dstore URL where the forked blocks of each bundle are written as a single object (optional)")
+	fs.BoolVar(&config.ForkLog, "fork-log", false, "write the forked blocks of each bundle as a JSONL object next to the merged files")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "print the files that would be written and deleted instead of touching the stores")
+	fs.BoolVar(&config.WatchOneBlockFiles, "watch-one-block-files", false, "watch a local one-block-files store for new files, on linux")
+	fs.BoolVar(&config.CompareOneBlockSources, "compare-one-block-sources", false, "download the one-block-files of every source and report the sources that disagree on the content of a block")
+	fs.BoolVar(&config.VerifyMergedUpload, "verify-merged-upload", false, "read back each merged file after writing it")
+	fs.BoolVar(&config.BundleIndex, "bundle-index", false, "write an index next to each merged file")
+	fs.StringVar(&config.NotifyWebhookURL, "notify-webhook-url", "", "URL receiving a JSON POST after each merged file")
+	fs.StringVar(&config.NotifyFilePath, "notify-file", "", "file receiving a JSON line after each merged file")
+	fs.DurationVar(&config.HeadStallThreshold, "head-stall-threshold", 0, "the merger becomes unhealthy when no block was processed for that long (0 disables it)")
+	fs.StringVar(&config.HoleMode, "hole-mode", "", "what to do with holes in the merged files: warn, start-at-first-hole or skip")
+	fs.StringVar(&config.StateFile, "state-file", "", "local path or dstore URL of the bundler checkpoint (optional)")
+	fs.DurationVar(&config.LeaseDuration, "lease-duration", 0, "enables leader election between replicas through a lease in the merged store (0 disables it)")
+	fs.StringVar(&config.LeaseHolderID, "lease-holder-id", "", "identifies this replica in the lease, defaults to hostname and pid")
+	fs.BoolVar(&config.BackfillHoles, "backfill-holes", false, "rebuild the missing merged files from one-block-files, then exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if config.TimeBetweenPruning <= 0 || config.TimeBetweenPolling <= 0 {
+		return fmt.Errorf("-time-between-pruning and -time-between-polling must be greater than 0")
+	}
+
+	if metricsListenAddr != "" {
+		go dmetrics.Serve(metricsListenAddr)
+	}
+
+	app := appmerger.New(config)
+	if err := app.Run(); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		zlog.Info("received signal, shutting down", zap.Stringer("signal", sig))
+		app.Shutdown(nil)
+	case <-app.Terminating():
+	}
+
+	<-app.Terminated()
+	return app.Err()
+}
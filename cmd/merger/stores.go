@@ -0,0 +1,121 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/merger"
+	appmerger "github.com/streamingfast/merger/app/merger"
+)
+
+// storeFlags are the stores and bundle size shared by the maintenance commands
+type storeFlags struct {
+	oneBlockStore       string
+	mergedStore         string
+	forkedStore         string
+	forkArchiveStore    string
+	oneBlockCompression string
+	mergedCompression   string
+	forkedCompression   string
+	bundleSize          uint64
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet, withOneBlocks bool) {
+	if withOneBlocks {
+		fs.StringVar(&f.oneBlockStore, "one-block-store", "", "dstore URL of the one-block-files")
+		fs.StringVar(&f.forkedStore, "forked-store", "", "dstore URL of the forked blocks (optional)")
+		fs.StringVar(&f.forkArchiveStore, "fork-archive-store", "", "dstore URL of the fork archives (optional)")
+		fs.StringVar(&f.oneBlockCompression, "one-block-compression", "", "compression of the one-block-files: zstd, gzip or none, with an optional level (ex: zstd:19)")
+		fs.StringVar(&f.forkedCompression, "forked-compression", "", "compression of the forked blocks files")
+	}
+	fs.StringVar(&f.mergedStore, "merged-store", "", "dstore URL of the merged files")
+	fs.StringVar(&f.mergedCompression, "merged-compression", "", "compression of the merged files")
+	fs.Uint64Var(&f.bundleSize, "bundle-size", appmerger.DefaultBundleSize, "number of blocks in each merged file")
+}
+
+// newIO opens the stores the same way `merger run` does, the one-block-files store is only required when withOneBlocks is set
+func (f *storeFlags) newIO(withOneBlocks bool) (merger.IOInterface, error) {
+	if f.mergedStore == "" {
+		return nil, fmt.Errorf("-merged-store is required")
+	}
+	if f.bundleSize == 0 {
+		return nil, fmt.Errorf("-bundle-size cannot be 0")
+	}
+	mergedBlocksStore, err := appmerger.NewDBinStore(f.mergedStore, f.mergedCompression)
+	if err != nil {
+		return nil, fmt.Errorf("opening merged blocks store: %w", err)
+	}
+
+	oneBlocksStore := mergedBlocksStore // not read by the commands that do not need one-block-files
+	if withOneBlocks {
+		if f.oneBlockStore == "" {
+			return nil, fmt.Errorf("-one-block-store is required")
+		}
+		oneBlocksStore, err = appmerger.NewDBinStore(f.oneBlockStore, f.oneBlockCompression)
+		if err != nil {
+			return nil, fmt.Errorf("opening one-block-files store: %w", err)
+		}
+	}
+
+	var forkedBlocksStore dstore.Store
+	if f.forkedStore != "" {
+		forkedBlocksStore, err = appmerger.NewDBinStore(f.forkedStore, f.forkedCompression)
+		if err != nil {
+			return nil, fmt.Errorf("opening forked blocks store: %w", err)
+		}
+	}
+
+	var opts []merger.DStoreIOOption
+	if f.forkArchiveStore != "" {
+		forkArchiveStore, err := merger.NewForkArchiveStore(f.forkArchiveStore)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, merger.WithForkArchiveStore(forkArchiveStore))
+	}
+
+	return merger.NewDStoreIO(zlog, tracer, oneBlocksStore, mergedBlocksStore, forkedBlocksStore, 5, 500*time.Millisecond, f.bundleSize, opts...), nil
+}
+
+// dstoreIO returns the DStoreIO behind io, fork-aware or not
+func dstoreIO(io merger.IOInterface) (*merger.DStoreIO, error) {
+	switch io := io.(type) {
+	case *merger.ForkAwareDStoreIO:
+		return io.DStoreIO, nil
+	case *merger.DStoreIO:
+		return io, nil
+	}
+	return nil, fmt.Errorf("unexpected IO type %T", io)
+}
+
+func parseBlockNums(args []string, names ...string) ([]uint64, error) {
+	if len(args) != len(names) {
+		return nil, fmt.Errorf("expecting %d arguments: %v", len(names), names)
+	}
+	out := make([]uint64, len(args))
+	for i, arg := range args {
+		num, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", names[i], arg, err)
+		}
+		out[i] = num
+	}
+	return out, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func verifyCmd(args []string) error {
+	var stores storeFlags
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	stores.register(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: merger verify [flags] <low-block-num> <high-block-num>\n\nprints a JSON report and exits with status 1 when issues are found\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	nums, err := parseBlockNums(fs.Args(), "low-block-num", "high-block-num")
+	if err != nil {
+		return err
+	}
+
+	io, err := stores.newIO(false)
+	if err != nil {
+		return err
+	}
+	dio, err := dstoreIO(io)
+	if err != nil {
+		return err
+	}
+
+	report, err := dio.Verify(context.Background(), nums[0], nums[1])
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("found %d issues in %d bundles", len(report.Issues), report.Bundles)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/streamingfast/dstore"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+// DBinExtension is the extension of the objects of dstore.NewDBinStore, kept for all compressions
+const DBinExtension = "dbin.zst"
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Compression is the codec and level used to write objects, level 0 means the codec's default
+type Compression struct {
+	Codec string
+	Level int
+}
+
+// ParseCompression reads a compression spec like `zstd`, `zstd:19`, `gzip:9` or `none`
+func ParseCompression(in string) (*Compression, error) {
+	codec, level := in, ""
+	if idx := strings.Index(in, ":"); idx != -1 {
+		codec, level = in[:idx], in[idx+1:]
+	}
+
+	out := &Compression{Codec: codec}
+	switch codec {
+	case CompressionNone, CompressionZstd, CompressionGzip:
+	default:
+		return nil, fmt.Errorf("invalid compression %q, expected one of %q, %q or %q, optionally followed by `:<level>`", in, CompressionNone, CompressionZstd, CompressionGzip)
+	}
+
+	if level != "" {
+		if codec == CompressionNone {
+			return nil, fmt.Errorf("invalid compression %q, %q does not take a level", in, CompressionNone)
+		}
+		l, err := strconv.Atoi(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression level in %q: %w", in, err)
+		}
+		out.Level = l
+	}
+	return out, nil
+}
+
+func (c *Compression) String() string {
+	if c.Level == 0 {
+		return c.Codec
+	}
+	return fmt.Sprintf("%s:%d", c.Codec, c.Level)
+}
+
+// CompressedStore writes objects with the given Compression, and reads them whatever the compression they were
+// written with (zstd, gzip or none, detected from their content). It keeps the object names of the underlying store.
+type CompressedStore struct {
+	dstore.Store
+	compression *Compression
+}
+
+// NewDBinStoreWithCompression is like dstore.NewDBinStore, writing with the given compression instead of the default zstd.
+// Objects keep the `dbin.zst` extension whatever their compression, so that a store holding objects written with
+// different compressions is listed as a whole and each object is decoded from its content. Readers using
+// dstore.NewDBinStore can only decode the zstd objects, use NewDBinStoreWithCompression to read the others.
+func NewDBinStoreWithCompression(baseURL string, compression *Compression) (*CompressedStore, error) {
+	store, err := dstore.NewStore(baseURL, DBinExtension, "", false) // compressed by the CompressedStore, for levels
+	if err != nil {
+		return nil, err
+	}
+	return WithCompression(store, compression), nil
+}
+
+// WithCompression wraps a store that does not compress by itself
+func WithCompression(store dstore.Store, compression *Compression) *CompressedStore {
+	return &CompressedStore{
+		Store:       store,
+		compression: compression,
+	}
+}
+
+func (s *CompressedStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := s.Store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := decompressingReader(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("reading object %s: %w", name, err)
+	}
+	return out, nil
+}
+
+func (s *CompressedStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	_, err := s.WriteObjectSize(ctx, base, f)
+	return err
+}
+
+// WriteObjectSize is WriteObject, also returning the size of the stored object, after compression
+func (s *CompressedStore) WriteObjectSize(ctx context.Context, base string, f io.Reader) (size uint64, err error) {
+	if s.compression.Codec == CompressionNone {
+		counter := &countingReader{Reader: f}
+		err = s.Store.WriteObject(ctx, base, counter)
+		return counter.count, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.compress(f, pw))
+	}()
+
+	counter := &countingReader{Reader: pr}
+	err = s.Store.WriteObject(ctx, base, counter)
+	pr.CloseWithError(err) // unblocks the compressing goroutine if the write failed
+	return counter.count, err
+}
+
+func (s *CompressedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := s.WriteObject(ctx, toBaseName, f); err != nil {
+		return err
+	}
+	return os.Remove(localFile)
+}
+
+func (s *CompressedStore) SubStore(subFolder string) (dstore.Store, error) {
+	store, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	return WithCompression(store, s.compression), nil
+}
+
+func (s *CompressedStore) compress(f io.Reader, w io.Writer) error {
+	var cw io.WriteCloser
+	switch s.compression.Codec {
+	case CompressionGzip:
+		level := gzip.DefaultCompression
+		if s.compression.Level != 0 {
+			level = s.compression.Level
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return err
+		}
+		cw = gw
+	case CompressionZstd:
+		var opts []zstd.EOption
+		if s.compression.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(s.compression.Level)))
+		}
+		zw, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	default:
+		return fmt.Errorf("unknown compression %q", s.compression.Codec)
+	}
+
+	if _, err := io.Copy(cw, f); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// decompressingReader detects the compression of an object from its first bytes
+func decompressingReader(reader io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	head, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create zstd reader: %w", err)
+		}
+		return &closingReader{ReadCloser: decoder.IOReadCloser(), under: reader}, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		gr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create gzip reader: %w", err)
+		}
+		return &closingReader{ReadCloser: gr, under: reader}, nil
+	default:
+		return &closingReader{ReadCloser: io.NopCloser(buffered), under: reader}, nil
+	}
+}
+
+// closingReader closes the decompressor, then the object reader underneath it
+type closingReader struct {
+	io.ReadCloser
+	under io.ReadCloser
+}
+
+func (r *closingReader) Close() error {
+	r.ReadCloser.Close()
+	return r.under.Close()
+}
@@ -0,0 +1,136 @@
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		in        string
+		expect    *Compression
+		expectErr bool
+	}{
+		{"zstd", &Compression{Codec: "zstd"}, false},
+		{"zstd:19", &Compression{Codec: "zstd", Level: 19}, false},
+		{"gzip:9", &Compression{Codec: "gzip", Level: 9}, false},
+		{"none", &Compression{Codec: "none"}, false},
+		{"none:1", nil, true},
+		{"zstd:high", nil, true},
+		{"lz4", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			c, err := ParseCompression(test.in)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, c)
+		})
+	}
+}
+
+func TestCompressedStore_MixedObjects(t *testing.T) {
+	content := strings.Repeat("dbin block content ", 100)
+	raw := dstore.NewMockStore(nil)
+
+	for i, spec := range []string{"zstd", "zstd:19", "gzip:9", "none"} {
+		c, err := ParseCompression(spec)
+		require.NoError(t, err)
+		store := WithCompression(raw, c)
+		name := fileNameForBlocksBundle(uint64(i * 100))
+		require.NoError(t, store.WriteObject(context.Background(), name, strings.NewReader(content)))
+
+		stored, err := raw.OpenObject(context.Background(), name)
+		require.NoError(t, err)
+		storedData, err := ioutil.ReadAll(stored)
+		require.NoError(t, err)
+		if spec == "none" {
+			assert.Equal(t, content, string(storedData))
+		} else {
+			assert.Less(t, len(storedData), len(content), spec)
+		}
+	}
+
+	// a single store reads all of them, whatever it writes with
+	store := WithCompression(raw, &Compression{Codec: "gzip"})
+	for i := 0; i < 4; i++ {
+		reader, err := store.OpenObject(context.Background(), fileNameForBlocksBundle(uint64(i*100)))
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, content, string(data))
+	}
+}
+
+func TestCompressedStore_WriteObjectSize(t *testing.T) {
+	content := strings.Repeat("dbin block content ", 100)
+
+	for _, spec := range []string{"zstd", "gzip", "none"} {
+		t.Run(spec, func(t *testing.T) {
+			c, err := ParseCompression(spec)
+			require.NoError(t, err)
+			raw := dstore.NewMockStore(nil)
+			size, err := WithCompression(raw, c).WriteObjectSize(context.Background(), "0000000100", strings.NewReader(content))
+			require.NoError(t, err)
+
+			stored, err := raw.OpenObject(context.Background(), "0000000100")
+			require.NoError(t, err)
+			storedData, err := ioutil.ReadAll(stored)
+			require.NoError(t, err)
+			assert.EqualValues(t, len(storedData), size)
+		})
+	}
+}
+
+func TestNewDBinStoreWithCompression_MixedObjects(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// written like the extractors and the existing merged files
+	dbinStore, err := dstore.NewDBinStore("file://" + dir)
+	require.NoError(t, err)
+	require.NoError(t, dbinStore.WriteObject(ctx, "0000000100", strings.NewReader("zstd content")))
+
+	for _, spec := range []string{"gzip", "none"} {
+		t.Run(spec, func(t *testing.T) {
+			c, err := ParseCompression(spec)
+			require.NoError(t, err)
+			store, err := NewDBinStoreWithCompression("file://"+dir, c)
+			require.NoError(t, err)
+			name := "0000000200-" + spec
+			require.NoError(t, store.WriteObject(ctx, name, strings.NewReader(spec+" content")))
+			_, err = os.Stat(filepath.Join(dir, name+"."+DBinExtension))
+			require.NoError(t, err)
+
+			var names []string
+			require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+				names = append(names, filename)
+				return nil
+			}))
+			assert.Contains(t, names, "0000000100")
+			assert.Contains(t, names, name)
+
+			for object, expected := range map[string]string{"0000000100": "zstd content", name: spec + " content"} {
+				reader, err := store.OpenObject(ctx, object)
+				require.NoError(t, err)
+				data, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+				assert.Equal(t, expected, string(data))
+			}
+		})
+	}
+}
@@ -20,5 +20,32 @@ var ListFilesTimeout = 10 * time.Minute
 var WriteObjectTimeout = 5 * time.Minute
 var GetObjectTimeout = 5 * time.Minute
 var DeleteObjectTimeout = 5 * time.Minute
+var NotifierTimeout = 30 * time.Second
 
+// HealthCheckInterval is how often the health status is evaluated for Watch streams and the readiness metric
+var HealthCheckInterval = time.Second
+
+// LeaseSettleDelay is the time waited before reading back the merger lease, to detect concurrent acquisitions
+var LeaseSettleDelay = 2 * time.Second
+
+// BundleSizeCheckFiles is the number of merged files looked at by CheckBundleSize
+var BundleSizeCheckFiles = 20
+
+// ParallelOneBlockDownload is the default number of workers pre-downloading irreversible one-block-files in the bundler
 const ParallelOneBlockDownload = 2
+
+// OneBlockDownloadWindow is the default maximum number of one-block-files queued or being pre-downloaded, the bundler waits when it is reached
+const OneBlockDownloadWindow = 200
+
+// OneBlockFullRelistInterval is how often the incremental listing of one-block-files does a full walk, as a safety net
+var OneBlockFullRelistInterval = time.Minute
+
+// OneBlockListingLookback is the number of blocks below the highest one-block-file seen where incremental listings start,
+// to catch files that show up late
+var OneBlockListingLookback = uint64(200)
+
+// OneBlockWatchBufferSize is the number of new one-block-files that can wait for the bundler when watching the store
+var OneBlockWatchBufferSize = 1000
+
+// PreMergedBlocksBufferSize is the number of irreversible blocks that can be queued for a PreMergedBlocks stream before it is closed for being too slow
+var PreMergedBlocksBufferSize = 1000
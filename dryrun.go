@@ -0,0 +1,158 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+)
+
+// DryRunIO lists and downloads through the IOInterface it wraps, but never writes nor deletes: it prints
+// the plan of what would have been done instead, one action per line.
+type DryRunIO struct {
+	IOInterface
+
+	planLock sync.Mutex
+	plan     io.Writer
+}
+
+type forkAwareDryRunIO struct {
+	*DryRunIO
+	forkAware ForkAwareIOInterface
+}
+
+// NewDryRunIO keeps the ForkAwareIOInterface of the wrapped IOInterface, if any
+func NewDryRunIO(io IOInterface, plan io.Writer) IOInterface {
+	dryRun := &DryRunIO{
+		IOInterface: io,
+		plan:        plan,
+	}
+	if forkAware, ok := io.(ForkAwareIOInterface); ok {
+		return &forkAwareDryRunIO{DryRunIO: dryRun, forkAware: forkAware}
+	}
+	return dryRun
+}
+
+func (d *DryRunIO) printf(format string, args ...interface{}) {
+	d.planLock.Lock()
+	defer d.planLock.Unlock()
+	fmt.Fprintf(d.plan, "[dry-run] "+format+"\n", args...)
+}
+
+func (d *DryRunIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (err error) {
+	var blocks []*bstream.OneBlockFile
+	for _, obf := range oneBlockFiles {
+		if obf.Num < inclusiveLowerBlock {
+			continue // the last block of the previous bundle is excluded, like in DStoreIO
+		}
+		if _, err := obf.Data(ctx, d.DownloadOneBlockFile); err != nil {
+			return fmt.Errorf("downloading %s: %w", obf.CanonicalName, err)
+		}
+		blocks = append(blocks, obf)
+	}
+
+	if len(blocks) == 0 {
+		d.printf("write merged file %s: empty", fileNameForBlocksBundle(inclusiveLowerBlock))
+		return nil
+	}
+	d.printf("write merged file %s: %d blocks, #%d to #%d", fileNameForBlocksBundle(inclusiveLowerBlock), len(blocks), blocks[0].Num, blocks[len(blocks)-1].Num)
+	return nil
+}
+
+func (d *DryRunIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error {
+	for _, filename := range sortedFilenames(oneBlockFiles) {
+		d.printf("delete one-block-file %s", filename)
+	}
+	return nil
+}
+
+func (d *DryRunIO) WalkNewOneBlockFiles(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	if incIO, ok := d.IOInterface.(IncrementalIOInterface); ok {
+		return incIO.WalkNewOneBlockFiles(ctx, inclusiveLowerBlock, callback)
+	}
+	return d.WalkOneBlockFiles(ctx, inclusiveLowerBlock, callback)
+}
+
+func (d *DryRunIO) ResetOneBlockListing() {
+	if incIO, ok := d.IOInterface.(IncrementalIOInterface); ok {
+		incIO.ResetOneBlockListing()
+	}
+}
+
+func (d *DryRunIO) SubscribeOneBlockFiles(ctx context.Context) (<-chan *bstream.OneBlockFile, error) {
+	if watcher, ok := d.IOInterface.(OneBlockWatcherIOInterface); ok {
+		return watcher.SubscribeOneBlockFiles(ctx)
+	}
+	return nil, ErrWatchNotSupported
+}
+
+func (d *forkAwareDryRunIO) MoveForkedBlocks(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) {
+	if archiver, ok := d.forkAware.(*ForkAwareDStoreIO); ok && archiver.forkArchiveStore != nil && len(oneBlockFiles) != 0 {
+		low, high := oneBlockFiles[0].Num, oneBlockFiles[0].Num
+		for _, obf := range oneBlockFiles {
+			if obf.Num < low {
+				low = obf.Num
+			}
+			if obf.Num > high {
+				high = obf.Num
+			}
+		}
+		d.printf("write fork archive %s: %d forked blocks", forkArchiveName(low, high), len(oneBlockFiles))
+	}
+	for _, filename := range sortedFilenames(oneBlockFiles) {
+		d.printf("move forked block %s", filename)
+	}
+}
+
+func (d *forkAwareDryRunIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclusiveHighBoundary uint64) {
+	lister, ok := d.forkAware.(interface {
+		ForkedFilesToDelete(ctx context.Context, inclusiveLowBoundary, inclusiveHighBoundary uint64) ([]string, error)
+	})
+	if !ok {
+		d.printf("delete forked blocks from #%d to #%d", inclusiveLowBoundary, inclusiveHighBoundary)
+		return
+	}
+
+	filenames, err := lister.ForkedFilesToDelete(context.Background(), inclusiveLowBoundary, inclusiveHighBoundary)
+	if err != nil {
+		d.printf("cannot list forked blocks to delete: %s", err)
+	}
+	for _, filename := range filenames {
+		d.printf("delete forked file %s", filename)
+	}
+
+	if archiver, ok := d.forkAware.(*ForkAwareDStoreIO); ok {
+		archives, err := archiver.ForkArchivesToDelete(context.Background(), inclusiveHighBoundary)
+		if err != nil {
+			d.printf("cannot list fork archives to delete: %s", err)
+		}
+		for _, name := range archives {
+			d.printf("delete fork archive %s", name)
+		}
+	}
+}
+
+func sortedFilenames(oneBlockFiles []*bstream.OneBlockFile) (out []string) {
+	for _, obf := range oneBlockFiles {
+		out = append(out, oneBlockFilenames(obf)...)
+	}
+	sort.Strings(out)
+	return
+}
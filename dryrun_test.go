@@ -0,0 +1,57 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunIO(t *testing.T) {
+	noWrites := func(base string, f io.Reader) error {
+		t.Errorf("unexpected write of %s", base)
+		return nil
+	}
+	noDeletes := func(_ context.Context, base string) error {
+		t.Errorf("unexpected delete of %s", base)
+		return nil
+	}
+
+	oneBlockStore := dstore.NewMockStore(noWrites)
+	oneBlockStore.DeleteObjectFunc = noDeletes
+	oneBlockStore.SetFile(block100.CanonicalName+"-suffix", []byte("data"))
+	oneBlockStore.SetFile(block101.CanonicalName+"-suffix", []byte("data"))
+	mergedStore := dstore.NewMockStore(noWrites)
+	forkedStore := dstore.NewMockStore(noWrites)
+	forkedStore.DeleteObjectFunc = noDeletes
+	forkedStore.SetFile("0000000099-0000000000000099b-0000000000000098a-97-suffix", nil)
+	forkedStore.SetFile("0000000105-0000000000000105b-0000000000000104a-97-suffix", nil)
+
+	plan := &bytes.Buffer{}
+	dryRun := NewDryRunIO(NewDStoreIO(testLogger, testTracer, oneBlockStore, mergedStore, forkedStore, 0, 0, 100), plan)
+
+	files := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile(block99.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block100.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block101.CanonicalName + "-suffix"),
+	}
+	require.NoError(t, dryRun.MergeAndStore(context.Background(), 100, files))
+	assert.Equal(t, []byte("data"), files[1].MemoizeData, "blocks are downloaded")
+	require.NoError(t, dryRun.DeleteAsync(files[:1]))
+
+	forkAware, ok := dryRun.(ForkAwareIOInterface)
+	require.True(t, ok)
+	forkAware.MoveForkedBlocks(context.Background(), []*bstream.OneBlockFile{bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")})
+	forkAware.DeleteForkedBlocksAsync(0, 100)
+
+	assert.Equal(t, `[dry-run] write merged file 0000000100: 2 blocks, #100 to #101
+[dry-run] delete one-block-file 0000000099-0000000000000099a-0000000000000098a-97-suffix
+[dry-run] move forked block 0000000101-0000000000000101b-0000000000000100a-99-suffix
+[dry-run] delete forked file 0000000099-0000000000000099b-0000000000000098a-97-suffix
+`, plan.String())
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// DefaultExplodeSourceID is the source of the one-block-files written by ExplodeBundle when none is given
+const DefaultExplodeSourceID = "exploded"
+
+// ExplodeBundle writes each block of the merged file at baseBlock back to the one-block-files store, as an extractor
+// would have written it: `NUM-ID-PREVID-LIBNUM-SOURCE`, with sourceID as the source. The merger picks these files up
+// like any other, so deleting a bad merged file then exploding a good copy of it lets a backfill rebuild it.
+func (s *DStoreIO) ExplodeBundle(ctx context.Context, baseBlock uint64, sourceID string) (filenames []string, err error) {
+	if sourceID == "" {
+		sourceID = DefaultExplodeSourceID
+	}
+	if strings.Contains(sourceID, "-") {
+		return nil, fmt.Errorf("invalid source ID %q: it cannot contain '-'", sourceID)
+	}
+
+	oneBlockFiles, err := splitMergedFile(ctx, s.mergedBlocksStore, baseBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obf := range oneBlockFiles {
+		filename := bstream.BlockFileNameWithSuffix(obf.ToBstreamBlock(), sourceID)
+		err := Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
+			inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+			defer cancel()
+			return s.oneBlocksStore.WriteObject(inCtx, filename, bytes.NewReader(obf.MemoizeData))
+		})
+		if err != nil {
+			return filenames, fmt.Errorf("writing one-block-file %s: %w", filename, err)
+		}
+		filenames = append(filenames, filename)
+	}
+
+	s.logger.Info("exploded merged file", zap.String("filename", fileNameForBlocksBundle(baseBlock)), zap.Int("one_block_files", len(filenames)), zap.String("source_id", sourceID))
+	return filenames, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplodeBundle(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 10
+	ctx := context.Background()
+
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	writeTestBundle(t, mergedBlocksStore, 100, 10)
+	oneBlocksStore := dstore.NewMockStore(nil)
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedBlocksStore, nil, 0, 0, 10).(*DStoreIO)
+
+	_, err := mio.ExplodeBundle(ctx, 100, "bad-source")
+	require.Error(t, err)
+
+	filenames, err := mio.ExplodeBundle(ctx, 100, "")
+	require.NoError(t, err)
+	require.Len(t, filenames, 10)
+	assert.Equal(t, "0000000100-00000064aa-00000063aa-99-exploded", filenames[0])
+
+	var walked []string
+	require.NoError(t, mio.WalkOneBlockFiles(ctx, 0, func(obf *bstream.OneBlockFile) error {
+		walked = append(walked, obf.CanonicalName)
+		return nil
+	}))
+	assert.Len(t, walked, 10)
+
+	// merging them again gives back the same merged file
+	files, err := splitMergedFile(ctx, mergedBlocksStore, 100)
+	require.NoError(t, err)
+	for _, f := range files {
+		f.MemoizeData = nil
+		f.Filenames = map[string]bool{bstream.BlockFileNameWithSuffix(f.ToBstreamBlock(), "exploded"): true}
+	}
+	remerged := dstore.NewMockStore(nil)
+	require.NoError(t, NewDStoreIO(testLogger, testTracer, oneBlocksStore, remerged, nil, 0, 0, 10).MergeAndStore(ctx, 100, files))
+
+	original, err := mergedBlocksStore.OpenObject(ctx, "0000000100")
+	require.NoError(t, err)
+	copied, err := remerged.OpenObject(ctx, "0000000100")
+	require.NoError(t, err)
+	originalData, _ := ioutil.ReadAll(original)
+	copiedData, _ := ioutil.ReadAll(copied)
+	assert.Equal(t, originalData, copiedData)
+}
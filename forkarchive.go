@@ -0,0 +1,180 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// forkArchiveMagic starts every fork archive. It is followed by the length of the JSON index (uint32, big endian),
+// the index itself, then the forked blocks as a dbin stream, like a merged file.
+var forkArchiveMagic = []byte("FORKARC1")
+
+const forkArchiveSuffix = "-forks"
+
+// ForkArchiveExtension is the extension of the fork archives. They are kept in a store of their own, tools walking
+// the forked blocks store expect one-block-files only.
+const ForkArchiveExtension = "zst"
+
+// ForkArchiveIndex lists the blocks of a fork archive. Offsets are relative to the start of the dbin stream.
+type ForkArchiveIndex struct {
+	Blocks []*ForkArchiveEntry `json:"blocks"`
+}
+
+type ForkArchiveEntry struct {
+	*BundleIndexEntry
+	Filenames []string `json:"filenames"` // original one-block-files names, with their source
+}
+
+func NewForkArchiveStore(url string) (dstore.Store, error) {
+	store, err := dstore.NewStore(url, ForkArchiveExtension, "zstd", false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create fork archive store: %w", err)
+	}
+	return store, nil
+}
+
+// forkArchiveName is `LOWNUM-HIGHNUM-forks`, so that archives sort by block number
+func forkArchiveName(lowBlockNum, highBlockNum uint64) string {
+	return fmt.Sprintf("%010d-%010d%s", lowBlockNum, highBlockNum, forkArchiveSuffix)
+}
+
+func parseForkArchiveName(name string) (lowBlockNum, highBlockNum uint64, ok bool) {
+	if !strings.HasSuffix(name, forkArchiveSuffix) {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, forkArchiveSuffix), "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	low, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	high, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return low, high, true
+}
+
+// writeForkArchive writes all the forked blocks in a single object of the fork archive store
+func (s *ForkAwareDStoreIO) writeForkArchive(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) error {
+	sorted := make([]*bstream.OneBlockFile, len(oneBlockFiles))
+	copy(sorted, oneBlockFiles)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Num < sorted[j].Num })
+
+	bundleReader, err := NewBundleReader(ctx, s.logger, s.tracer, sorted, sorted[0], s.DownloadOneBlockFile)
+	if err != nil {
+		return err
+	}
+	bundleReader.EnableIndex()
+	data, err := ioutil.ReadAll(bundleReader)
+	if err != nil {
+		return fmt.Errorf("reading forked blocks: %w", err)
+	}
+
+	index := &ForkArchiveIndex{}
+	for i, entry := range bundleReader.Index() {
+		index.Blocks = append(index.Blocks, &ForkArchiveEntry{BundleIndexEntry: entry, Filenames: oneBlockFilenames(sorted[i])})
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	archive := &bytes.Buffer{}
+	archive.Write(forkArchiveMagic)
+	binary.Write(archive, binary.BigEndian, uint32(len(indexData)))
+	archive.Write(indexData)
+	archive.Write(data)
+
+	name := forkArchiveName(sorted[0].Num, sorted[len(sorted)-1].Num)
+	err = Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
+		inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+		defer cancel()
+		return s.forkArchiveStore.WriteObject(inCtx, name, bytes.NewReader(archive.Bytes()))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("wrote fork archive", zap.String("name", name), zap.Int("forked_blocks", len(sorted)), zap.Int("bytes", archive.Len()))
+	return nil
+}
+
+// ForkArchivesToDelete lists the fork archives that DeleteForkedBlocksAsync deletes, the ones that end at or below
+// inclusiveHighBoundary
+func (s *ForkAwareDStoreIO) ForkArchivesToDelete(ctx context.Context, inclusiveHighBoundary uint64) (names []string, err error) {
+	if s.forkArchiveStore == nil {
+		return nil, nil
+	}
+	err = s.forkArchiveStore.WalkFrom(ctx, "", "", func(filename string) error {
+		low, high, ok := parseForkArchiveName(filename)
+		if !ok {
+			return nil // .tmp files and the likes
+		}
+		if low > inclusiveHighBoundary {
+			return io.EOF
+		}
+		if high <= inclusiveHighBoundary {
+			names = append(names, filename)
+		}
+		return nil
+	})
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+// ReadForkArchive returns the index of a fork archive, and a reader positioned at the start of its dbin stream
+func ReadForkArchive(reader io.Reader) (*ForkArchiveIndex, io.Reader, error) {
+	magic := make([]byte, len(forkArchiveMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, nil, fmt.Errorf("reading fork archive header: %w", err)
+	}
+	if !bytes.Equal(magic, forkArchiveMagic) {
+		return nil, nil, fmt.Errorf("not a fork archive")
+	}
+
+	var indexLen uint32
+	if err := binary.Read(reader, binary.BigEndian, &indexLen); err != nil {
+		return nil, nil, fmt.Errorf("reading fork archive header: %w", err)
+	}
+	indexData := make([]byte, indexLen)
+	if _, err := io.ReadFull(reader, indexData); err != nil {
+		return nil, nil, fmt.Errorf("reading fork archive index: %w", err)
+	}
+
+	index := &ForkArchiveIndex{}
+	if err := json.Unmarshal(indexData, index); err != nil {
+		return nil, nil, fmt.Errorf("decoding fork archive index: %w", err)
+	}
+	return index, reader, nil
+}
@@ -0,0 +1,83 @@
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForkArchiveName(t *testing.T) {
+	low, high, ok := parseForkArchiveName(forkArchiveName(101, 199))
+	require.True(t, ok)
+	assert.EqualValues(t, 101, low)
+	assert.EqualValues(t, 199, high)
+
+	_, _, ok = parseForkArchiveName("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	assert.False(t, ok)
+}
+
+func TestForkArchives(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	oneBlockDir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(oneBlockDir, "0000000101-0000000000000101b-0000000000000100a-99-suffix"), []byte(`{"id":"0000000000000101b","num":101}`+"\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(oneBlockDir, "0000000102-0000000000000102b-0000000000000101b-99-suffix"), []byte(`{"id":"0000000000000102b","num":102}`+"\n"), 0644))
+	oneBlockStore, err := dstore.NewStore("file://"+oneBlockDir, "", "", false)
+	require.NoError(t, err)
+	forkedDir := t.TempDir()
+	forkedStore, err := dstore.NewStore("file://"+forkedDir, "", "", false)
+	require.NoError(t, err)
+	archiveDir := t.TempDir()
+	archiveStore, err := NewForkArchiveStore("file://" + archiveDir)
+	require.NoError(t, err)
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, dstore.NewMockStore(nil), forkedStore, 0, 0, 100, WithForkArchiveStore(archiveStore)).(*ForkAwareDStoreIO)
+	mio.MoveForkedBlocks(context.Background(), []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000102-0000000000000102b-0000000000000101b-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix"),
+	})
+
+	forked, err := ioutil.ReadDir(forkedDir)
+	require.NoError(t, err)
+	assert.Empty(t, forked, "archives never go to the forked blocks store")
+
+	files, err := archiveStore.ListFiles(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0000000101-0000000102-forks"}, files)
+	_, err = os.Stat(filepath.Join(archiveDir, "0000000101-0000000102-forks."+ForkArchiveExtension))
+	require.NoError(t, err)
+
+	reader, err := archiveStore.OpenObject(context.Background(), files[0])
+	require.NoError(t, err)
+	index, blocks, err := ReadForkArchive(reader)
+	require.NoError(t, err)
+	require.Len(t, index.Blocks, 2)
+	assert.EqualValues(t, 101, index.Blocks[0].Num)
+	assert.Equal(t, []string{"0000000102-0000000000000102b-0000000000000101b-99-suffix"}, index.Blocks[1].Filenames)
+
+	data, err := ioutil.ReadAll(blocks)
+	require.NoError(t, err)
+	reader.Close()
+	second := index.Blocks[1]
+	assert.Equal(t, `{"id":"0000000000000102b","num":102}`+"\n", string(data[second.Offset:second.Offset+second.Length]))
+
+	_, err = mio.PruneForkedBlocks(context.Background(), 0, 101)
+	require.NoError(t, err)
+	exists, err := archiveStore.FileExists(context.Background(), files[0])
+	require.NoError(t, err)
+	assert.True(t, exists, "archive holds blocks above the pruning boundary")
+
+	deleted, err := mio.PruneForkedBlocks(context.Background(), 0, 102)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	exists, err = archiveStore.FileExists(context.Background(), files[0])
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ForkLogExtension is the extension of the fork log objects. With a fork log store opened on the merged blocks
+// store URL, the forks of bundle `0000012300` are written next to it as `0000012300.forks.jsonl`
+const ForkLogExtension = "forks.jsonl"
+
+// ForkRecord describes a forked block, found while merging a bundle
+type ForkRecord struct {
+	BundleBaseBlockNum uint64    `json:"bundle"`
+	BlockNum           uint64    `json:"block_num"`
+	ForkedID           string    `json:"forked_id"`
+	ForkedPreviousID   string    `json:"forked_previous_id"`
+	CanonicalID        string    `json:"canonical_id,omitempty"` // empty when no irreversible block exists at this height
+	Sources            []string  `json:"sources"`
+	DiscoveredAt       time.Time `json:"discovered_at"`
+}
+
+// ForkLog writes the forked blocks of each bundle as a JSONL object
+type ForkLog struct {
+	store  dstore.Store
+	logger *zap.Logger
+}
+
+func NewForkLogStore(url string) (dstore.Store, error) {
+	store, err := dstore.NewStore(url, ForkLogExtension, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create fork log store: %w", err)
+	}
+	return store, nil
+}
+
+func NewForkLog(logger *zap.Logger, store dstore.Store) *ForkLog {
+	return &ForkLog{
+		store:  store,
+		logger: logger,
+	}
+}
+
+func (l *ForkLog) Write(ctx context.Context, baseBlockNum uint64, records []*ForkRecord) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return l.store.WriteObject(ctx, fileNameForBlocksBundle(baseBlockNum), buf)
+}
+
+func ReadForkLog(ctx context.Context, store dstore.Store, baseBlockNum uint64) ([]*ForkRecord, error) {
+	reader, err := store.OpenObject(ctx, fileNameForBlocksBundle(baseBlockNum))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var out []*ForkRecord
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		record := &ForkRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("cannot decode fork log %s: %w", fileNameForBlocksBundle(baseBlockNum), err)
+		}
+		out = append(out, record)
+	}
+	return out, scanner.Err()
+}
+
+func (m *Merger) writeForkLog(baseBlockNum uint64, records []*ForkRecord) {
+	err := Retry(m.logger, 5, time.Second, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), WriteObjectTimeout)
+		defer cancel()
+		return m.forkLog.Write(ctx, baseBlockNum, records)
+	})
+	if err != nil {
+		m.logger.Warn("cannot write fork log", zap.Uint64("base_block_num", baseBlockNum), zap.Int("forked_blocks", len(records)), zap.Error(err))
+	}
+}
+
+// oneBlockFileSources returns the source suffix of each of the block's filenames (NUM-ID-PREVID-LIBNUM-SOURCE)
+func oneBlockFileSources(obf *bstream.OneBlockFile) []string {
+	var out []string
+	for _, filename := range oneBlockFilenames(obf) {
+		if source := oneBlockFileSource(filename); source != "" {
+			out = append(out, source)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// oneBlockFileSource returns the SOURCE part of a `NUM-ID-PREVID-LIBNUM-SOURCE` filename, or "" when there is none
+func oneBlockFileSource(filename string) string {
+	parts := strings.SplitN(filename, "-", 5)
+	if len(parts) != 5 {
+		return ""
+	}
+	return parts[4]
+}
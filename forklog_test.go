@@ -0,0 +1,27 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForkLog(t *testing.T) {
+	store := dstore.NewMockStore(nil)
+	forkLog := NewForkLog(testLogger, store)
+
+	discoveredAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []*ForkRecord{
+		{BundleBaseBlockNum: 100, BlockNum: 101, ForkedID: "101b", ForkedPreviousID: "100a", CanonicalID: "101a", Sources: []string{"source1"}, DiscoveredAt: discoveredAt},
+		{BundleBaseBlockNum: 100, BlockNum: 102, ForkedID: "102b", ForkedPreviousID: "101b", Sources: []string{"source1", "source2"}, DiscoveredAt: discoveredAt},
+	}
+	require.NoError(t, forkLog.Write(context.Background(), 100, records))
+
+	read, err := ReadForkLog(context.Background(), store, 100)
+	require.NoError(t, err)
+	assert.Equal(t, records, read)
+}
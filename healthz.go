@@ -16,28 +16,80 @@ package merger
 
 import (
 	"context"
+	"time"
 
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// healthStatus is SERVING once the bundler processed a block, as long as merging does not fail and the head does not stall
+func (m *Merger) healthStatus() (pbhealth.HealthCheckResponse_ServingStatus, error) {
+	if m.IsTerminating() {
+		return pbhealth.HealthCheckResponse_NOT_SERVING, m.Err()
+	}
+	if err := m.bundler.Health(m.headStallThreshold); err != nil {
+		return pbhealth.HealthCheckResponse_NOT_SERVING, err
+	}
+	return pbhealth.HealthCheckResponse_SERVING, nil
+}
+
 // Check is basic GRPC Healthcheck
 func (m *Merger) Check(ctx context.Context, in *pbhealth.HealthCheckRequest) (*pbhealth.HealthCheckResponse, error) {
-	status := pbhealth.HealthCheckResponse_SERVING
+	status, _ := m.healthStatus()
 	return &pbhealth.HealthCheckResponse{
 		Status: status,
 	}, nil
 }
 
-// Watch is basic GRPC Healthcheck as a stream
+// Watch is basic GRPC Healthcheck as a stream, sending the current status then every status change
 func (m *Merger) Watch(req *pbhealth.HealthCheckRequest, stream pbhealth.Health_WatchServer) error {
-	err := stream.Send(&pbhealth.HealthCheckResponse{
-		Status: pbhealth.HealthCheckResponse_SERVING,
-	})
-	if err != nil {
-		return err
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	lastStatus := pbhealth.HealthCheckResponse_UNKNOWN
+	for {
+		if status, _ := m.healthStatus(); status != lastStatus {
+			if err := stream.Send(&pbhealth.HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+			lastStatus = status
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
 	}
+}
+
+// startHealthMonitor keeps the readiness metric in sync with the health status, logging the transitions
+func (m *Merger) startHealthMonitor() {
+	go func() {
+		ticker := time.NewTicker(HealthCheckInterval)
+		defer ticker.Stop()
+
+		lastStatus := pbhealth.HealthCheckResponse_UNKNOWN
+		for {
+			status, err := m.healthStatus()
+			if status != lastStatus {
+				if status == pbhealth.HealthCheckResponse_SERVING {
+					metrics.AppReadiness.SetReady()
+					m.logger.Info("merger is healthy")
+				} else {
+					metrics.AppReadiness.SetNotReady()
+					m.logger.Info("merger is not healthy", zap.Error(err))
+				}
+				lastStatus = status
+			}
 
-	// The merger is always serving, so just want until this stream is canceled out
-	<-stream.Context().Done()
-	return nil
+			select {
+			case <-m.Terminated():
+				metrics.AppReadiness.SetNotReady()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
 }
@@ -2,6 +2,7 @@ package merger
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -21,12 +22,38 @@ func TestHealthz_Check(t *testing.T) {
 		time.Second,
 		time.Second,
 		0,
+		WithHeadStallThreshold(time.Minute),
 	)
+	defer m.Shutdown(nil)
 	request := &pbhealth.HealthCheckRequest{}
-	resp, err := m.Check(ctx, request)
-	if err != nil {
-		panic(err)
+
+	check := func() pbhealth.HealthCheckResponse_ServingStatus {
+		resp, err := m.Check(ctx, request)
+		if err != nil {
+			panic(err)
+		}
+		return resp.Status
 	}
 
-	require.Equal(t, resp.Status, pbhealth.HealthCheckResponse_SERVING)
+	require.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, check(), "no block processed")
+
+	m.bundler.lastBlockProcessedAt = time.Now()
+	require.Equal(t, pbhealth.HealthCheckResponse_SERVING, check())
+
+	m.bundler.lastMergeError = fmt.Errorf("failed")
+	require.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, check(), "merge failed")
+	m.bundler.lastMergeError = nil
+
+	m.bundler.bundleError <- fmt.Errorf("failed")
+	require.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, check(), "bundle error")
+	<-m.bundler.bundleError
+
+	m.bundler.lastBlockProcessedAt = time.Now().Add(-2 * time.Minute)
+	require.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, check(), "head stalled")
+
+	m.bundler.lastBlockProcessedAt = time.Now()
+	require.Equal(t, pbhealth.HealthCheckResponse_SERVING, check())
+
+	m.Shutdown(nil)
+	require.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, check(), "terminating")
 }
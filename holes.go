@@ -0,0 +1,86 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// HoleMode tells the merger what to do when it finds a hole in the merged blocks store
+type HoleMode string
+
+const (
+	// HoleModeWarn starts at the first hole, logging a warning about it (default)
+	HoleModeWarn HoleMode = "warn"
+	// HoleModeStartAtFirstHole starts at the first hole on purpose, only reporting it
+	HoleModeStartAtFirstHole HoleMode = "start-at-first-hole"
+	// HoleModeSkip jumps over every hole, starting after the last contiguous merged file
+	HoleModeSkip HoleMode = "skip"
+)
+
+func ParseHoleMode(in string) (HoleMode, error) {
+	switch mode := HoleMode(in); mode {
+	case "":
+		return HoleModeWarn, nil
+	case HoleModeWarn, HoleModeStartAtFirstHole, HoleModeSkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid hole mode %q, expecting one of %q, %q or %q", in, HoleModeWarn, HoleModeStartAtFirstHole, HoleModeSkip)
+}
+
+// nextBundle calls NextBundle on the IOInterface, handling the holes according to the merger's HoleMode
+func (m *Merger) nextBundle(ctx context.Context, lowestBaseBlock uint64) (base uint64, lib bstream.BlockRef, err error) {
+	base, lib, err = m.io.NextBundle(ctx, lowestBaseBlock)
+
+	var holeErr *HoleError
+	for errors.As(err, &holeErr) {
+		switch m.holeMode {
+		case HoleModeStartAtFirstHole:
+			m.reportHole(holeErr, false)
+			return base, lib, nil
+		case HoleModeSkip:
+			m.reportHole(holeErr, true)
+			base, lib, err = m.io.NextBundle(ctx, holeErr.HighBlockNum)
+		default:
+			return base, lib, err
+		}
+	}
+	return base, lib, err
+}
+
+// reportHole logs and counts each hole only once, since we go through the merged files on every poll
+func (m *Merger) reportHole(holeErr *HoleError, skipped bool) {
+	if m.reportedHoles[holeErr.LowBlockNum] {
+		return
+	}
+	m.reportedHoles[holeErr.LowBlockNum] = true
+
+	metrics.HolesFound.Inc()
+	if skipped {
+		metrics.HoleBlocksSkipped.AddUint64(holeErr.HighBlockNum - holeErr.LowBlockNum)
+	}
+	m.logger.Info("found hole in merged files",
+		zap.Uint64("hole_low_block_num", holeErr.LowBlockNum),
+		zap.Uint64("hole_high_block_num", holeErr.HighBlockNum),
+		zap.Bool("skipped", skipped),
+		zap.String("hole_mode", string(m.holeMode)),
+	)
+}
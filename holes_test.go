@@ -0,0 +1,73 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHoleMode(t *testing.T) {
+	mode, err := ParseHoleMode("")
+	require.NoError(t, err)
+	assert.Equal(t, HoleModeWarn, mode)
+
+	mode, err = ParseHoleMode("skip")
+	require.NoError(t, err)
+	assert.Equal(t, HoleModeSkip, mode)
+
+	_, err = ParseHoleMode("ignore")
+	require.Error(t, err)
+}
+
+func TestMergerNextBundleHoles(t *testing.T) {
+	// merged files: 100, 300, 400, 700
+	io := &TestMergerIO{
+		NextBundleFunc: func(_ context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+			switch lowestBaseBlock {
+			case 100:
+				return 200, bstream.NewBlockRef("199a", 199), &HoleError{LowBlockNum: 200, HighBlockNum: 300}
+			case 300:
+				return 500, bstream.NewBlockRef("499a", 499), &HoleError{LowBlockNum: 500, HighBlockNum: 700}
+			case 700:
+				return 800, bstream.NewBlockRef("799a", 799), nil
+			}
+			return lowestBaseBlock, nil, nil
+		},
+	}
+
+	tests := []struct {
+		mode        HoleMode
+		expectBase  uint64
+		expectLIB   uint64
+		expectErr   bool
+		expectHoles int
+	}{
+		{mode: HoleModeWarn, expectBase: 200, expectLIB: 199, expectErr: true},
+		{mode: HoleModeStartAtFirstHole, expectBase: 200, expectLIB: 199, expectHoles: 1},
+		{mode: HoleModeSkip, expectBase: 800, expectLIB: 799, expectHoles: 2},
+	}
+
+	for _, c := range tests {
+		t.Run(string(c.mode), func(t *testing.T) {
+			m := NewMerger(testLogger, "", io, 1, 100, 100, time.Second, time.Second, 0, WithHoleMode(c.mode))
+			defer m.Shutdown(nil)
+
+			base, lib, err := m.nextBundle(context.Background(), 100)
+			if c.expectErr {
+				require.ErrorIs(t, err, ErrHoleFound)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.EqualValues(t, c.expectBase, base)
+			assert.EqualValues(t, c.expectLIB, lib.Num())
+			assert.Len(t, m.reportedHoles, c.expectHoles)
+
+			_, _, _ = m.nextBundle(context.Background(), 100)
+			assert.Len(t, m.reportedHoles, c.expectHoles)
+		})
+	}
+}
@@ -15,6 +15,11 @@
 package merger
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/logging"
 )
 
@@ -22,4 +27,18 @@ var testLogger, testTracer = logging.PackageLogger("merger", "github.com/streami
 
 func init() {
 	logging.InstantiateLoggers()
+
+	bstream.GetBlockReaderFactory = bstream.BlockReaderFactoryFunc(testBlockReaderFactory)
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+}
+
+// testBlockReaderFactory reads real dbin blocks, as written by bstream.NewDBinBlockWriter, and the JSON test blocks of
+// bstream.TestBlockReaderFactory otherwise
+func testBlockReaderFactory(reader io.Reader) (bstream.BlockReader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(4)
+	if bytes.Equal(magic, []byte("dbin")) {
+		return bstream.NewDBinBlockReader(buffered, nil)
+	}
+	return bstream.TestBlockReaderFactory.New(buffered)
 }
@@ -0,0 +1,207 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+var ErrLeaseLost = errors.New("merger lease lost")
+
+// Lease is a lock object kept in a store, so that only one of many merger replicas is active.
+// dstore has no compare-and-swap primitive: after writing, the lease is read back after LeaseSettleDelay
+// to make sure that no other replica took it at the same time. Clocks of the replicas must be roughly in sync.
+type Lease struct {
+	store    dstore.Store // must allow overwrites
+	name     string
+	holderID string
+	duration time.Duration
+	logger   *zap.Logger
+}
+
+type leaseRecord struct {
+	HolderID  string    `json:"holder_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewLease(logger *zap.Logger, store dstore.Store, name, holderID string, duration time.Duration) *Lease {
+	return &Lease{
+		store:    store,
+		name:     name,
+		holderID: holderID,
+		duration: duration,
+		logger:   logger,
+	}
+}
+
+// TryAcquire takes the lease if it is free, expired or already ours
+func (l *Lease) TryAcquire(ctx context.Context) (bool, error) {
+	rec, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if rec != nil && rec.HolderID != l.holderID && time.Now().Before(rec.ExpiresAt) {
+		l.logger.Debug("lease held by another merger", zap.String("holder_id", rec.HolderID), zap.Time("expires_at", rec.ExpiresAt))
+		return false, nil
+	}
+
+	if err := l.write(ctx, time.Now().Add(l.duration)); err != nil {
+		return false, err
+	}
+
+	time.Sleep(LeaseSettleDelay)
+	rec, err = l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return rec != nil && rec.HolderID == l.holderID, nil
+}
+
+// Renew extends the lease, it returns ErrLeaseLost if another merger took it
+func (l *Lease) Renew(ctx context.Context) error {
+	rec, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.HolderID != l.holderID {
+		return ErrLeaseLost
+	}
+	return l.write(ctx, time.Now().Add(l.duration))
+}
+
+// Release expires the lease right away if we hold it, so a standby merger can take over without waiting
+func (l *Lease) Release(ctx context.Context) error {
+	rec, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.HolderID != l.holderID {
+		return nil
+	}
+	return l.write(ctx, time.Now())
+}
+
+func (l *Lease) read(ctx context.Context) (*leaseRecord, error) {
+	exists, err := l.store.FileExists(ctx, l.name)
+	if err != nil {
+		return nil, fmt.Errorf("checking lease %q: %w", l.name, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	reader, err := l.store.OpenObject(ctx, l.name)
+	if err != nil {
+		return nil, fmt.Errorf("opening lease %q: %w", l.name, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading lease %q: %w", l.name, err)
+	}
+
+	rec := &leaseRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("decoding lease %q: %w", l.name, err)
+	}
+	return rec, nil
+}
+
+func (l *Lease) write(ctx context.Context, expiresAt time.Time) error {
+	data, err := json.Marshal(&leaseRecord{
+		HolderID:  l.holderID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	return l.store.WriteObject(ctx, l.name, bytes.NewReader(data))
+}
+
+// waitForLease blocks until the lease is acquired, returns false if the merger is terminating before that
+func (m *Merger) waitForLease() bool {
+	interval := m.lease.duration / 3
+	var waitingLogged bool
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		acquired, err := m.lease.TryAcquire(ctx)
+		cancel()
+		if err != nil {
+			m.logger.Warn("cannot acquire merger lease", zap.Error(err))
+		}
+		if acquired {
+			m.logger.Info("merger lease acquired, becoming active", zap.String("holder_id", m.lease.holderID))
+			return true
+		}
+		if !waitingLogged {
+			m.logger.Info("merger lease held by another merger, standing by", zap.String("holder_id", m.lease.holderID))
+			waitingLogged = true
+		}
+
+		select {
+		case <-m.Terminating():
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+// keepLease renews the lease until the merger terminates, shutting it down if the lease is lost
+func (m *Merger) keepLease() {
+	interval := m.lease.duration / 3
+	lastRenewed := time.Now()
+
+	m.OnTerminated(func(_ error) {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		if err := m.lease.Release(ctx); err != nil {
+			m.logger.Warn("cannot release merger lease", zap.Error(err))
+		}
+	})
+
+	go func() {
+		for {
+			select {
+			case <-m.Terminating():
+				return
+			case <-time.After(interval):
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := m.lease.Renew(ctx)
+			cancel()
+			if err == nil {
+				lastRenewed = time.Now()
+				continue
+			}
+			if errors.Is(err, ErrLeaseLost) || time.Since(lastRenewed) > m.lease.duration {
+				m.logger.Error("merger lease lost, shutting down", zap.Error(err))
+				m.Shutdown(ErrLeaseLost)
+				return
+			}
+			m.logger.Warn("cannot renew merger lease, will retry", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	LeaseSettleDelay = 0
+	ctx := context.Background()
+
+	store := dstore.NewMockStore(nil)
+	store.SetOverwrite(true)
+
+	a := NewLease(testLogger, store, "merger.lease", "a", 50*time.Millisecond)
+	b := NewLease(testLogger, store, "merger.lease", "b", 50*time.Millisecond)
+
+	acquired, err := a.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = b.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.False(t, acquired, "held by a")
+
+	require.NoError(t, a.Renew(ctx))
+	require.ErrorIs(t, b.Renew(ctx), ErrLeaseLost)
+
+	time.Sleep(60 * time.Millisecond)
+	acquired, err = b.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired, "expired")
+	require.ErrorIs(t, a.Renew(ctx), ErrLeaseLost)
+
+	require.NoError(t, a.Release(ctx), "not ours, noop")
+	acquired, err = a.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, b.Release(ctx))
+	acquired, err = a.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired, "released")
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
 	"github.com/streamingfast/shutter"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -38,9 +39,87 @@ type Merger struct {
 	timeBetweenPruning   time.Duration
 	pruningDistanceToLIB uint64
 
+	holeMode      HoleMode
+	reportedHoles map[uint64]bool
+
+	stateFile *StateFile
+
+	headStallThreshold time.Duration
+
+	lease *Lease
+
+	forkLog *ForkLog
+
+	statusListenAddr string
+
+	bundlerOptions []BundlerOption // also given to the bundlers of the backfill
+
+	watchOneBlockFiles bool
+	newOneBlockFiles   <-chan *bstream.OneBlockFile // set when watching the one-block-files store
+
 	bundler *Bundler
 }
 
+// MergerOption configures an optional feature of a Merger
+type MergerOption func(m *Merger)
+
+// WithHoleMode tells what to do with the holes of the merged blocks store, HoleModeWarn by default
+func WithHoleMode(mode HoleMode) MergerOption {
+	return func(m *Merger) {
+		m.holeMode = mode
+	}
+}
+
+// WithStateFile keeps the bundler checkpoint in stateFile after each merge, and resumes from it on start
+func WithStateFile(stateFile *StateFile) MergerOption {
+	return func(m *Merger) {
+		m.stateFile = stateFile
+	}
+}
+
+// WithHeadStallThreshold makes the merger unhealthy when no block was processed for that long
+func WithHeadStallThreshold(threshold time.Duration) MergerOption {
+	return func(m *Merger) {
+		m.headStallThreshold = threshold
+	}
+}
+
+// WithLease only lets the merger work while it holds the lease, for leader election between replicas
+func WithLease(lease *Lease) MergerOption {
+	return func(m *Merger) {
+		m.lease = lease
+	}
+}
+
+// WithForkLog writes the forked blocks found in each bundle to forkLog
+func WithForkLog(forkLog *ForkLog) MergerOption {
+	return func(m *Merger) {
+		m.forkLog = forkLog
+	}
+}
+
+// WithStatusListenAddr serves the HTTP status API on addr
+func WithStatusListenAddr(addr string) MergerOption {
+	return func(m *Merger) {
+		m.statusListenAddr = addr
+	}
+}
+
+// WithOneBlockFilesWatcher passes new one-block-files to the bundler as soon as they are written, when the IO
+// supports it. The polling is kept as a fallback.
+func WithOneBlockFilesWatcher() MergerOption {
+	return func(m *Merger) {
+		m.watchOneBlockFiles = true
+	}
+}
+
+// WithBundlerOptions configures the bundler of the merger, and those of the backfill
+func WithBundlerOptions(opts ...BundlerOption) MergerOption {
+	return func(m *Merger) {
+		m.bundlerOptions = append(m.bundlerOptions, opts...)
+	}
+}
+
 func NewMerger(
 	logger *zap.Logger,
 	grpcListenAddr string,
@@ -52,19 +131,39 @@ func NewMerger(
 	timeBetweenPruning time.Duration,
 	timeBetweenPolling time.Duration,
 	stopBlock uint64,
+	opts ...MergerOption,
 ) *Merger {
 	m := &Merger{
 		Shutter:              shutter.New(),
-		bundler:              NewBundler(firstStreamableBlock, stopBlock, firstStreamableBlock, bundleSize, io),
 		grpcListenAddr:       grpcListenAddr,
 		io:                   io,
 		firstStreamableBlock: firstStreamableBlock,
 		pruningDistanceToLIB: pruningDistanceToLIB,
 		timeBetweenPolling:   timeBetweenPolling,
 		timeBetweenPruning:   timeBetweenPruning,
+		holeMode:             HoleModeWarn,
+		reportedHoles:        make(map[uint64]bool),
 		logger:               logger,
 	}
-	m.OnTerminating(func(_ error) { m.bundler.inProcess.Lock(); m.bundler.inProcess.Unlock() }) // finish bundle that may be merging async
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.bundler = NewBundler(logger, firstStreamableBlock, stopBlock, firstStreamableBlock, bundleSize, io, m.bundlerOptions...)
+
+	if m.stateFile != nil {
+		if err := m.resumeFromState(context.Background()); err != nil {
+			logger.Info("not resuming from state file, discovering start block from merged files", zap.Error(err))
+		}
+		m.bundler.OnBundleMerged(m.saveState)
+	}
+	if m.forkLog != nil {
+		m.bundler.OnForkedBlocks(m.writeForkLog)
+	}
+	m.OnTerminating(func(_ error) {
+		m.bundler.inProcess.Lock() // finish bundle that may be merging async
+		m.bundler.inProcess.Unlock()
+		m.bundler.Close()
+	})
 
 	return m
 }
@@ -73,9 +172,24 @@ func (m *Merger) Run() {
 	m.logger.Info("starting merger")
 
 	m.startGRPCServer()
+	m.startHealthMonitor()
+	if m.statusListenAddr != "" {
+		m.startStatusServer()
+	}
+
+	if m.lease != nil {
+		if !m.waitForLease() {
+			m.Shutdown(nil)
+			return
+		}
+		m.keepLease()
+	}
 
 	m.startOldFilesPruner()
 	m.startForkedBlocksPruner()
+	if m.watchOneBlockFiles {
+		m.startOneBlockFilesWatcher()
+	}
 
 	err := m.run()
 	if err != nil {
@@ -84,6 +198,26 @@ func (m *Merger) Run() {
 	m.Shutdown(err)
 }
 
+func (m *Merger) startOneBlockFilesWatcher() {
+	watcher, ok := m.io.(OneBlockWatcherIOInterface)
+	if !ok {
+		m.logger.Warn("cannot watch one-block-files with this IO, polling only")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.OnTerminating(func(_ error) { cancel() })
+
+	newFiles, err := watcher.SubscribeOneBlockFiles(ctx)
+	if err != nil {
+		cancel()
+		m.logger.Warn("cannot watch one-block-files, polling only", zap.Error(err))
+		return
+	}
+	m.logger.Info("watching one-block-files store for new files")
+	m.newOneBlockFiles = newFiles
+}
+
 func (m *Merger) startForkedBlocksPruner() {
 	forkableIO, ok := m.io.(ForkAwareIOInterface)
 	if !ok {
@@ -102,6 +236,7 @@ func (m *Merger) startForkedBlocksPruner() {
 
 			pruningTarget := m.pruningTarget(m.pruningDistanceToLIB)
 			forkableIO.DeleteForkedBlocksAsync(bstream.GetProtocolFirstStreamableBlock, pruningTarget)
+			metrics.PrunerRuns.Inc("forked_blocks")
 
 			if spentTime := time.Since(now); spentTime < m.timeBetweenPruning {
 				delay = m.timeBetweenPruning - spentTime
@@ -152,6 +287,7 @@ func (m *Merger) startOldFilesPruner() {
 			}
 
 			m.io.DeleteAsync(toDelete)
+			metrics.PrunerRuns.Inc("one_block_files")
 		}
 	}()
 }
@@ -176,7 +312,7 @@ func (m *Merger) run() error {
 			return nil
 		}
 
-		base, lib, err := m.io.NextBundle(ctx, m.bundler.baseBlockNum)
+		base, lib, err := m.nextBundle(ctx, m.bundler.baseBlockNum)
 		if err != nil {
 			if errors.Is(err, ErrHoleFound) {
 				if holeFoundLogged {
@@ -206,11 +342,22 @@ func (m *Merger) run() error {
 			}
 			m.logger.Info("resetting bundler base block num", logFields...)
 			m.bundler.Reset(base, lib)
+			if incIO, ok := m.io.(IncrementalIOInterface); ok {
+				incIO.ResetOneBlockListing()
+			}
 		}
 
-		err = m.io.WalkOneBlockFiles(ctx, m.bundler.baseBlockNum, func(obf *bstream.OneBlockFile) error {
+		var walked uint64
+		handle := func(obf *bstream.OneBlockFile) error {
+			walked++
 			return m.bundler.HandleBlockFile(obf)
-		})
+		}
+		if incIO, ok := m.io.(IncrementalIOInterface); ok {
+			err = incIO.WalkNewOneBlockFiles(ctx, m.bundler.baseBlockNum, handle)
+		} else {
+			err = m.io.WalkOneBlockFiles(ctx, m.bundler.baseBlockNum, handle)
+		}
+		metrics.FilesWalkedPerPoll.SetUint64(walked)
 		if err != nil {
 			if err == ErrStopBlockReached {
 				m.logger.Info("stop block reached")
@@ -219,6 +366,17 @@ func (m *Merger) run() error {
 			return err
 		}
 
+		if m.newOneBlockFiles != nil {
+			if err := m.handleNewOneBlockFiles(now.Add(m.timeBetweenPolling)); err != nil {
+				if err == ErrStopBlockReached {
+					m.logger.Info("stop block reached")
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
 		if spentTime := time.Since(now); spentTime < m.timeBetweenPolling {
 			time.Sleep(m.timeBetweenPolling - spentTime)
 		}
@@ -23,6 +23,20 @@ var ErrHoleFound = errors.New("hole found in merged files")
 var DefaultFilesDeleteBatchSize = 10000
 var DefaultFilesDeleteThreads = 8
 
+// HoleError is returned by NextBundle when the merged files are not contiguous, it wraps ErrHoleFound
+type HoleError struct {
+	LowBlockNum  uint64 // base of the first missing bundle
+	HighBlockNum uint64 // base of the next existing bundle, exclusive boundary of the hole
+}
+
+func (e *HoleError) Error() string {
+	return fmt.Sprintf("%s: merged blocks skip from %d to %d, you need to fill this hole, set firstStreamableBlock above this hole or set merger hole mode to %q", ErrHoleFound, e.LowBlockNum, e.HighBlockNum, HoleModeSkip)
+}
+
+func (e *HoleError) Unwrap() error {
+	return ErrHoleFound
+}
+
 type IOInterface interface {
 
 	// NextBundle will read through consecutive merged blocks, starting at `lowestBaseBlock`, and return the next bundle that needs to be created
@@ -54,6 +68,8 @@ type ForkAwareDStoreIO struct {
 	*DStoreIO
 	forkedBlocksStore dstore.Store
 	forkOd            *oneBlockFilesDeleter
+
+	archiveOd *oneBlockFilesDeleter
 }
 
 type DStoreIO struct {
@@ -65,10 +81,62 @@ type DStoreIO struct {
 
 	bundleSize uint64
 
+	notifier   BundleNotifier
+	indexStore dstore.Store
+
+	verifyMergedUpload bool // read back each merged file after writing it, and rewrite it if it does not match
+
+	// compareOneBlockSources downloads the one-block-files of every source for each block, instead of the first one
+	// that opens, and reports the sources that do not agree on its content
+	compareOneBlockSources bool
+
+	forkArchiveStore dstore.Store // nil unless fork archives are enabled, only used with a forked blocks store
+
 	logger *zap.Logger
 	tracer logging.Tracer
 	od     *oneBlockFilesDeleter
 	forkOd *oneBlockFilesDeleter
+
+	listing *oneBlockListing
+}
+
+// DStoreIOOption configures an optional feature of a DStoreIO
+type DStoreIOOption func(s *DStoreIO)
+
+// WithBundleNotifier announces each merged bundle to notifier
+func WithBundleNotifier(notifier BundleNotifier) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.notifier = notifier
+	}
+}
+
+// WithBundleIndexStore writes the block index of each merged bundle to indexStore
+func WithBundleIndexStore(indexStore dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.indexStore = indexStore
+	}
+}
+
+// WithMergedUploadVerification reads back each merged file after writing it, and rewrites it if it does not match
+func WithMergedUploadVerification() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.verifyMergedUpload = true
+	}
+}
+
+// WithForkArchiveStore archives the forked blocks of each bundle to forkArchiveStore, when a forked blocks store is set
+func WithForkArchiveStore(forkArchiveStore dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.forkArchiveStore = forkArchiveStore
+	}
+}
+
+// WithOneBlockSourcesComparison downloads the one-block-files of every source for each block, and reports the sources
+// that do not agree on its content
+func WithOneBlockSourcesComparison() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.compareOneBlockSources = true
+	}
 }
 
 func NewDStoreIO(
@@ -80,9 +148,10 @@ func NewDStoreIO(
 	retryAttempts int,
 	retryCooldown time.Duration,
 	bundleSize uint64,
+	opts ...DStoreIOOption,
 ) IOInterface {
 
-	od := &oneBlockFilesDeleter{store: oneBlocksStore, logger: logger}
+	od := &oneBlockFilesDeleter{store: oneBlocksStore, name: "one_blocks", logger: logger}
 	od.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
 	dstoreIO := &DStoreIO{
 		oneBlocksStore:    oneBlocksStore,
@@ -93,6 +162,10 @@ func NewDStoreIO(
 		logger:            logger,
 		tracer:            tracer,
 		od:                od,
+		listing:           newOneBlockListing(),
+	}
+	for _, opt := range opts {
+		opt(dstoreIO)
 	}
 
 	forkAware := forkedBlocksStore != nil
@@ -100,14 +173,20 @@ func NewDStoreIO(
 		return dstoreIO
 	}
 
-	forkOd := &oneBlockFilesDeleter{store: forkedBlocksStore, logger: logger}
+	forkOd := &oneBlockFilesDeleter{store: forkedBlocksStore, name: "forked_blocks", logger: logger}
 	forkOd.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
 
-	return &ForkAwareDStoreIO{
+	forkAwareIO := &ForkAwareDStoreIO{
 		DStoreIO:          dstoreIO,
 		forkedBlocksStore: forkedBlocksStore,
 		forkOd:            forkOd,
 	}
+	if dstoreIO.forkArchiveStore != nil {
+		archiveOd := &oneBlockFilesDeleter{store: dstoreIO.forkArchiveStore, name: "fork_archives", logger: logger}
+		archiveOd.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
+		forkAwareIO.archiveOd = archiveOd
+	}
+	return forkAwareIO
 }
 
 func (s *DStoreIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (err error) {
@@ -139,24 +218,95 @@ func (s *DStoreIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64
 
 	s.logger.Info("about to write merged blocks to storage location", zapFields...)
 
+	var written uint64
+	var index []*BundleIndexEntry
+	var mismatch bool
 	err = Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
 		inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
 		defer cancel()
+		if mismatch { // the mismatching file is only deleted right before rewriting it, it is kept if all attempts fail
+			if err := s.mergedBlocksStore.DeleteObject(inCtx, bundleFilename); err != nil {
+				return fmt.Errorf("deleting mismatching merged file: %w", err)
+			}
+			mismatch = false
+		}
 		bundleReader, err := NewBundleReader(ctx, s.logger, s.tracer, filteredOBF, anyOneBlockFile, s.DownloadOneBlockFile)
 		if err != nil {
 			return err
 		}
-		return s.mergedBlocksStore.WriteObject(inCtx, bundleFilename, bundleReader)
+		if s.indexStore != nil {
+			bundleReader.EnableIndex()
+		}
+		if sizer, ok := s.mergedBlocksStore.(objectSizeWriter); ok {
+			written, err = sizer.WriteObjectSize(inCtx, bundleFilename, bundleReader)
+		} else {
+			counter := &countingReader{Reader: bundleReader}
+			err = s.mergedBlocksStore.WriteObject(inCtx, bundleFilename, counter)
+			written = counter.count // exact for the stores that do not compress on their own
+		}
+		index = bundleReader.Index()
+		if err != nil {
+			return err
+		}
+
+		if s.verifyMergedUpload {
+			if err := s.checkMergedObject(inCtx, bundleFilename, filteredOBF); err != nil {
+				metrics.MergedUploadMismatches.Inc()
+				s.logger.Warn("merged file read back does not match its one-block-files", zap.String("filename", bundleFilename), zap.Error(err))
+				mismatch = true
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("write object error: %s", err)
 	}
 
-	s.logger.Info("merged and uploaded", zap.String("filename", fileNameForBlocksBundle(inclusiveLowerBlock)), zap.Duration("merge_time", time.Since(t0)))
+	metrics.MergeDuration.ObserveSince(t0)
+	metrics.MergedBytes.AddUint64(written)
+	metrics.LastMergedObjectBytes.SetUint64(written)
+	metrics.MergedBlocks.AddInt(len(filteredOBF))
+	metrics.LastBundleBlocks.SetUint64(uint64(len(filteredOBF)))
+
+	s.logger.Info("merged and uploaded", zap.String("filename", fileNameForBlocksBundle(inclusiveLowerBlock)), zap.Duration("merge_time", time.Since(t0)), zap.Uint64("bytes", written))
+
+	if s.indexStore != nil {
+		s.writeIndex(ctx, inclusiveLowerBlock, index)
+	}
+
+	if s.notifier != nil {
+		bundle := &BundleInfo{
+			BaseBlockNum: inclusiveLowerBlock,
+			ObjectName:   bundleFilename,
+			ObjectURL:    s.mergedBlocksStore.ObjectURL(bundleFilename),
+			Size:         written,
+		}
+		if len(filteredOBF) != 0 {
+			bundle.FirstBlock = newBlockRef(filteredOBF[0])
+			bundle.LastBlock = newBlockRef(filteredOBF[len(filteredOBF)-1])
+		}
+		if err := s.notifier.NotifyBundle(ctx, bundle); err != nil {
+			s.logger.Warn("cannot notify merged bundle", zap.String("filename", bundleFilename), zap.Error(err))
+		}
+	}
 
 	return
 }
 
+// writeIndex writes the sidecar index of a merged file. Failing to do so is not fatal to the merger, it is only logged.
+func (s *DStoreIO) writeIndex(ctx context.Context, baseBlockNum uint64, entries []*BundleIndexEntry) {
+	index := &BundleIndex{BaseBlockNum: baseBlockNum, Blocks: entries}
+	err := Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
+		inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+		defer cancel()
+		return WriteBundleIndex(inCtx, s.indexStore, index)
+	})
+	if err != nil {
+		s.logger.Warn("cannot write bundle index", zap.String("filename", fileNameForBlocksBundle(baseBlockNum)), zap.Error(err))
+	}
+}
+
 func (s *DStoreIO) WalkOneBlockFiles(ctx context.Context, lowestBlock uint64, callback func(*bstream.OneBlockFile) error) error {
 
 	return s.oneBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(lowestBlock), func(filename string) error {
@@ -173,7 +323,12 @@ func (s *DStoreIO) WalkOneBlockFiles(ctx context.Context, lowestBlock uint64, ca
 
 }
 
+// DownloadOneBlockFile is called through OneBlockFile.Data(), which holds the lock protecting the block's filenames
 func (s *DStoreIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) (data []byte, err error) {
+	if s.compareOneBlockSources && len(oneBlockFile.Filenames) > 1 {
+		return s.downloadAllSources(ctx, oneBlockFile)
+	}
+
 	for filename := range oneBlockFile.Filenames { // will try to get MemoizeData from any of those files
 		var out io.ReadCloser
 		out, err = s.oneBlocksStore.OpenObject(ctx, filename)
@@ -205,11 +360,11 @@ func (s *DStoreIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (outB
 	err = s.mergedBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(lowestBaseBlock), func(filename string) error {
 		num, err := strconv.ParseUint(filename, 10, 64)
 		if err != nil {
-			return err
+			return nil // not a merged blocks file, ex: merger lease
 		}
 
 		if num != outBaseBlock {
-			return fmt.Errorf("%w: merged blocks skip from %d to %d, you need to fill this hole, set firstStreamableBlock above this hole or set merger option to ignore holes", ErrHoleFound, outBaseBlock, num)
+			return &HoleError{LowBlockNum: outBaseBlock, HighBlockNum: num}
 		}
 		outBaseBlock += s.bundleSize
 		lastFound = &num
@@ -229,6 +384,43 @@ func (s *DStoreIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (outB
 	return
 }
 
+// CheckBundleSize looks at the spacing of the first merged files in the store and returns an error if they were
+// not written with the given bundleSize. An empty store, or one with a single file aligned on bundleSize, is accepted.
+func CheckBundleSize(ctx context.Context, mergedBlocksStore dstore.Store, bundleSize uint64) error {
+	var nums []uint64
+	err := mergedBlocksStore.Walk(ctx, "", func(filename string) error {
+		num, err := strconv.ParseUint(filename, 10, 64)
+		if err != nil {
+			return nil // not a merged blocks file
+		}
+		nums = append(nums, num)
+		if len(nums) >= BundleSizeCheckFiles {
+			return dstore.StopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, dstore.StopIteration) {
+		return fmt.Errorf("walking merged blocks store: %w", err)
+	}
+
+	var minSpacing uint64
+	for i, num := range nums {
+		if num%bundleSize != 0 {
+			return fmt.Errorf("merged file %s is not aligned on bundle size %d", fileNameForBlocksBundle(num), bundleSize)
+		}
+		if i == 0 {
+			continue
+		}
+		if spacing := num - nums[i-1]; minSpacing == 0 || spacing < minSpacing {
+			minSpacing = spacing
+		}
+	}
+	if minSpacing != 0 && minSpacing != bundleSize {
+		return fmt.Errorf("merged files in store are %d blocks apart, but bundle size is %d", minSpacing, bundleSize)
+	}
+	return nil
+}
+
 func (s *DStoreIO) readLastBlockFromMerged(ctx context.Context, baseBlock uint64) (bstream.BlockRef, *time.Time, error) {
 	subCtx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
 	defer cancel()
@@ -244,13 +436,52 @@ func (s *DStoreIO) readLastBlockFromMerged(ctx context.Context, baseBlock uint64
 	return bstream.NewBlockRef(bstream.TruncateBlockID(last.Id), last.Number), &last.Timestamp, nil
 }
 
+// ReadBundle returns the blocks of the merged file starting at baseBlock, in the order they were written
+func (s *DStoreIO) ReadBundle(ctx context.Context, baseBlock uint64) (out []*bstream.Block, err error) {
+	subCtx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
+	defer cancel()
+
+	reader, err := s.mergedBlocksStore.OpenObject(subCtx, fileNameForBlocksBundle(baseBlock))
+	if err != nil {
+		return nil, fmt.Errorf("opening merged file %s: %w", fileNameForBlocksBundle(baseBlock), err)
+	}
+	defer reader.Close()
+
+	blkReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged file %s: %w", fileNameForBlocksBundle(baseBlock), err)
+	}
+
+	for {
+		block, err := blkReader.Read()
+		if block != nil {
+			out = append(out, block)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return nil, fmt.Errorf("reading merged file %s: %w", fileNameForBlocksBundle(baseBlock), err)
+		}
+	}
+}
+
 func (s *DStoreIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error {
 	return s.od.Delete(oneBlockFiles)
 }
 
 func (s *ForkAwareDStoreIO) MoveForkedBlocks(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) {
+	if s.forkArchiveStore != nil && len(oneBlockFiles) != 0 {
+		err := s.writeForkArchive(ctx, oneBlockFiles)
+		if err == nil {
+			_ = s.od.Delete(oneBlockFiles)
+			return
+		}
+		s.logger.Warn("could not write fork archive, copying forked blocks one by one", zap.Error(err))
+	}
+
 	for _, f := range oneBlockFiles {
-		for name := range f.Filenames {
+		for _, name := range oneBlockFilenames(f) {
 			reader, err := s.oneBlocksStore.OpenObject(ctx, name)
 			if err != nil {
 				s.logger.Warn("could not copy forked block", zap.Error(err))
@@ -269,8 +500,29 @@ func (s *ForkAwareDStoreIO) MoveForkedBlocks(ctx context.Context, oneBlockFiles
 }
 
 func (s *ForkAwareDStoreIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclusiveHighBoundary uint64) {
-	var forkedBlockFiles []*bstream.OneBlockFile
-	err := s.forkedBlocksStore.WalkFrom(context.Background(), "", "", func(filename string) error {
+	filenames, err := s.ForkedFilesToDelete(context.Background(), inclusiveLowBoundary, inclusiveHighBoundary)
+	if err != nil {
+		s.logger.Warn("cannot walk forked block files to delete old ones",
+			zap.Uint64("inclusive_low_boundary", inclusiveLowBoundary),
+			zap.Uint64("inclusive_high_boundary", inclusiveHighBoundary),
+			zap.Error(err),
+		)
+	}
+	s.forkOd.DeleteFilenames(filenames)
+
+	if s.forkArchiveStore == nil {
+		return
+	}
+	archives, err := s.ForkArchivesToDelete(context.Background(), inclusiveHighBoundary)
+	if err != nil {
+		s.logger.Warn("cannot walk fork archives to delete old ones", zap.Uint64("inclusive_high_boundary", inclusiveHighBoundary), zap.Error(err))
+	}
+	s.archiveOd.DeleteFilenames(archives)
+}
+
+// ForkedFilesToDelete lists the forked one-block-files that DeleteForkedBlocksAsync deletes, up to inclusiveHighBoundary
+func (s *ForkAwareDStoreIO) ForkedFilesToDelete(ctx context.Context, inclusiveLowBoundary, inclusiveHighBoundary uint64) (filenames []string, err error) {
+	err = s.forkedBlocksStore.WalkFrom(ctx, "", "", func(filename string) error {
 		if strings.HasSuffix(filename, ".tmp") {
 			return nil
 		}
@@ -278,19 +530,13 @@ func (s *ForkAwareDStoreIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclus
 		if obf.Num > inclusiveHighBoundary {
 			return io.EOF
 		}
-		forkedBlockFiles = append(forkedBlockFiles, obf)
+		filenames = append(filenames, filename)
 		return nil
 	})
-
-	if err != nil && err != io.EOF {
-		s.logger.Warn("cannot walk forked block files to delete old ones",
-			zap.Uint64("inclusive_low_boundary", inclusiveLowBoundary),
-			zap.Uint64("inclusive_high_boundary", inclusiveHighBoundary),
-			zap.Error(err),
-		)
+	if err == io.EOF {
+		err = nil
 	}
-
-	s.forkOd.Delete(forkedBlockFiles)
+	return
 }
 
 type oneBlockFilesDeleter struct {
@@ -299,7 +545,9 @@ type oneBlockFilesDeleter struct {
 	retryAttempts int
 	retryCooldown time.Duration
 	store         dstore.Store
+	name          string // used as metrics label
 	logger        *zap.Logger
+	pending       sync.WaitGroup
 }
 
 func (od *oneBlockFilesDeleter) Start(threads int, maxDeletions int) {
@@ -310,19 +558,34 @@ func (od *oneBlockFilesDeleter) Start(threads int, maxDeletions int) {
 }
 
 func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) error {
+	var fileNames []string
+	for _, oneBlockFile := range oneBlockFiles {
+		fileNames = append(fileNames, oneBlockFilenames(oneBlockFile)...)
+	}
+	return od.DeleteFilenames(fileNames)
+}
+
+// oneBlockFilenames returns a sorted copy of the filenames of a block. The bundler adds the filenames of other sources
+// to the object held by forkable, so they are read under its lock
+func oneBlockFilenames(obf *bstream.OneBlockFile) []string {
+	obf.Lock()
+	defer obf.Unlock()
+	out := make([]string, 0, len(obf.Filenames))
+	for filename := range obf.Filenames {
+		out = append(out, filename)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (od *oneBlockFilesDeleter) DeleteFilenames(fileNames []string) error {
 	od.Lock()
 	defer od.Unlock()
 
-	if len(oneBlockFiles) == 0 {
+	if len(fileNames) == 0 {
 		return nil
 	}
 
-	var fileNames []string
-	for _, oneBlockFile := range oneBlockFiles {
-		for filename := range oneBlockFile.Filenames {
-			fileNames = append(fileNames, filename)
-		}
-	}
 	od.logger.Info("deleting a bunch of one_block_files", zap.Int("number_of_files", len(fileNames)), zap.String("first_file", fileNames[0]), zap.String("last_file", fileNames[len(fileNames)-1]), zap.Stringer("store", od.store.BaseURL()))
 
 	deletable := make(map[string]bool)
@@ -332,10 +595,12 @@ func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) er
 	}
 
 	// dedupe processing queue
+	var drained int
 	for empty := false; !empty; {
 		select {
 		case f := <-od.toProcess:
 			deletable[f] = true
+			drained++
 		default:
 			empty = true
 		}
@@ -348,20 +613,25 @@ func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) er
 	sort.Strings(deletableArr)
 
 	var err error
-	for _, file := range deletableArr {
+	for i, file := range deletableArr {
 		if len(od.toProcess) == cap(od.toProcess) {
 			od.logger.Warn("skipping file deletions: the channel is full", zap.Int("capacity", cap(od.toProcess)))
+			metrics.SkippedDeletions.AddInt(len(deletableArr)-i, od.name)
 			err = fmt.Errorf("skipped some files")
 			break
 		}
+		od.pending.Add(1)
 		od.toProcess <- file
 	}
+	od.pending.Add(-drained) // they were queued again above, or skipped
+	metrics.DeleterQueueDepth.SetInt(len(od.toProcess), od.name)
 	return err
 }
 
 func (od *oneBlockFilesDeleter) processDeletions() {
 	for {
 		file := <-od.toProcess
+		metrics.DeleterQueueDepth.SetInt(len(od.toProcess), od.name)
 		err := Retry(od.logger, od.retryAttempts, od.retryCooldown, func() error {
 			ctx, cancel := context.WithTimeout(context.Background(), DeleteObjectTimeout)
 			defer cancel()
@@ -374,9 +644,15 @@ func (od *oneBlockFilesDeleter) processDeletions() {
 		if err != nil {
 			od.logger.Warn("cannot delete oneblock file after a few retries", zap.String("file", file), zap.Error(err))
 		}
+		od.pending.Done()
 	}
 }
 
+// Wait blocks until every queued file has been deleted, or given up on
+func (od *oneBlockFilesDeleter) Wait() {
+	od.pending.Wait()
+}
+
 func lastBlock(mergeFileReader io.ReadCloser) (out *bstream.Block, err error) {
 	defer mergeFileReader.Close()
 
@@ -400,3 +676,19 @@ func lastBlock(mergeFileReader io.ReadCloser) (out *bstream.Block, err error) {
 
 	return out, nil
 }
+
+// objectSizeWriter is implemented by the stores that know the size of the objects they write, like CompressedStore
+type objectSizeWriter interface {
+	WriteObjectSize(ctx context.Context, base string, f io.Reader) (size uint64, err error)
+}
+
+type countingReader struct {
+	io.Reader
+	count uint64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.count += uint64(n)
+	return
+}
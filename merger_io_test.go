@@ -16,6 +16,9 @@ package merger
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
@@ -115,6 +118,141 @@ func TestMergerIO_MergeUploadPerfect(t *testing.T) {
 	assert.Equal(t, expectFilenames, filesRead)
 }
 
+func TestMergerIO_MergeUploadWithIndex(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		obf := bstream.MustNewOneBlockFile(name)
+		return ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"id":%q,"prev":%q}`+"\n", fullTestBlockID(obf.ID), fullTestBlockID(obf.PreviousID)))), nil
+	}
+	indexStore := dstore.NewMockStore(nil)
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithBundleIndexStore(indexStore))
+	files := []*bstream.OneBlockFile{ // not the shared test blocks, their data gets memoized
+		bstream.MustNewOneBlockFile(block99.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block100.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block101.CanonicalName + "-suffix"),
+	}
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, files))
+
+	index, err := ReadBundleIndex(context.Background(), indexStore, 100)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, index.BaseBlockNum)
+	require.Len(t, index.Blocks, 2)
+	record := fmt.Sprintf(`{"id":%q,"prev":%q}`+"\n", fullTestBlockID("0000000000000100a"), fullTestBlockID("0000000000000099a"))
+	checksum := sha256.Sum256([]byte(record))
+	assert.Equal(t, &BundleIndexEntry{Num: 100, ID: fullTestBlockID("0000000000000100a"), PreviousID: fullTestBlockID("0000000000000099a"), Offset: 0, Length: uint64(len(record)), SHA256: hex.EncodeToString(checksum[:]), Timestamp: index.Blocks[0].Timestamp}, index.Blocks[0])
+	assert.Len(t, index.Blocks[0].ID, 64)
+	assert.EqualValues(t, len(record), index.Blocks[1].Offset)
+	assert.Equal(t, fullTestBlockID("0000000000000101a"), index.Blocks[1].ID)
+}
+
+func TestMergerIO_MergeUploadNotifiesStoredSize(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(strings.Repeat(`{"id":"0000000000000100a"}`+"\n", 50))), nil
+	}
+	rawMergedStore := dstore.NewMockStore(nil)
+	var notified *BundleInfo
+	notifier := testNotifier(func(_ context.Context, bundle *BundleInfo) error {
+		notified = bundle
+		return nil
+	})
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, WithCompression(rawMergedStore, &Compression{Codec: CompressionZstd}), nil, 0, 0, 100, WithBundleNotifier(notifier))
+	files := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile(block100.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block101.CanonicalName + "-suffix"),
+	}
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, files))
+
+	stored, err := rawMergedStore.OpenObject(context.Background(), "0000000100")
+	require.NoError(t, err)
+	storedData, err := ioutil.ReadAll(stored)
+	require.NoError(t, err)
+	require.NotNil(t, notified)
+	assert.EqualValues(t, len(storedData), notified.Size, "compressed size")
+	assert.Less(t, notified.Size, uint64(2*50*len(`{"id":"0000000000000100a"}`+"\n")))
+}
+
+func TestMergerIO_MergeUploadVerify(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.SetFile(block100.CanonicalName+"-suffix", []byte(`{"id":"0000000000000100a","num":100}`+"\n"))
+	oneBlockStore.SetFile(block101.CanonicalName+"-suffix", []byte(`{"id":"0000000000000101a","num":101}`+"\n"))
+
+	var writes int
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.WriteObjectFunc = func(_ context.Context, base string, f io.Reader) error {
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		writes++
+		if writes == 1 {
+			data = data[:len(data)/2] // truncated upload
+		}
+		mergedBlocksStore.SetFile(base, data)
+		return nil
+	}
+
+	files := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile(block100.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block101.CanonicalName + "-suffix"),
+	}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, mergedBlocksStore, nil, 3, 0, 100, WithMergedUploadVerification()).(*DStoreIO)
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, files))
+	assert.Equal(t, 2, writes)
+	require.NoError(t, mio.checkMergedObject(context.Background(), "0000000100", files))
+
+	assert.Error(t, mio.checkMergedObject(context.Background(), "0000000100", files[:1]), "extra block")
+	assert.Error(t, mio.checkMergedObject(context.Background(), "0000000100", []*bstream.OneBlockFile{files[1], files[0]}), "wrong order")
+}
+
+func TestMergerIO_MergeUploadVerifyKeepsLastAttempt(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.SetFile(block100.CanonicalName+"-suffix", []byte(`{"id":"0000000000000100a","num":100}`+"\n"))
+	oneBlockStore.SetFile(block101.CanonicalName+"-suffix", []byte(`{"id":"0000000000000101a","num":101}`+"\n"))
+
+	var writes int
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.WriteObjectFunc = func(_ context.Context, base string, f io.Reader) error {
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		writes++
+		mergedBlocksStore.SetFile(base, data[:len(data)/2]) // always truncated
+		return nil
+	}
+
+	files := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile(block100.CanonicalName + "-suffix"),
+		bstream.MustNewOneBlockFile(block101.CanonicalName + "-suffix"),
+	}
+	counting := &deleteCountingStore{MockStore: mergedBlocksStore}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, counting, nil, 3, 0, 100, WithMergedUploadVerification())
+	require.Error(t, mio.MergeAndStore(context.Background(), 100, files))
+	assert.Equal(t, 3, writes)
+	assert.Equal(t, 2, counting.deletes, "only before rewriting")
+
+	exists, err := mergedBlocksStore.FileExists(context.Background(), "0000000100")
+	require.NoError(t, err)
+	assert.True(t, exists, "last attempt kept")
+}
+
+type deleteCountingStore struct {
+	*dstore.MockStore
+	deletes int
+}
+
+func (s *deleteCountingStore) DeleteObject(ctx context.Context, base string) error {
+	s.deletes++
+	return s.MockStore.DeleteObject(ctx, base)
+}
+
 func TestMergerIO_MergeUploadFiltered(t *testing.T) {
 	files := []*bstream.OneBlockFile{
 		block98,
@@ -191,3 +329,52 @@ func TestMergerIO_MergeUploadFilteredToZero(t *testing.T) {
 	err := mio.MergeAndStore(context.Background(), 114, files)
 	require.NoError(t, err)
 }
+
+func TestMergerIO_NextBundleHole(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.SetFile("0000000100", []byte(`{"id":"0000000000000199a","num":199}`+"\n"))
+	mergedBlocksStore.SetFile("0000000300", []byte(`{"id":"0000000000000399a","num":399}`+"\n"))
+
+	mio := newDStoreIO(dstore.NewMockStore(nil), mergedBlocksStore)
+
+	base, lib, err := mio.NextBundle(context.Background(), 100)
+	require.ErrorIs(t, err, ErrHoleFound)
+	var holeErr *HoleError
+	require.ErrorAs(t, err, &holeErr)
+	assert.EqualValues(t, 200, holeErr.LowBlockNum)
+	assert.EqualValues(t, 300, holeErr.HighBlockNum)
+	assert.EqualValues(t, 200, base)
+	assert.EqualValues(t, 199, lib.Num())
+}
+
+func TestCheckBundleSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		bundleSize uint64
+		expectErr  bool
+	}{
+		{"empty store", nil, 1000, false},
+		{"single aligned file", []string{"0000001000"}, 1000, false},
+		{"single unaligned file", []string{"0000000100"}, 1000, true},
+		{"matching", []string{"0000000000", "0000000100", "0000000200"}, 100, false},
+		{"matching with hole", []string{"0000000000", "0000000100", "0000000500"}, 100, false},
+		{"smaller bundles in store", []string{"0000000000", "0000000100", "0000000200"}, 1000, true},
+		{"bigger bundles in store", []string{"0000000000", "0000001000", "0000002000"}, 100, true},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			store := dstore.NewMockStore(nil)
+			for _, f := range c.files {
+				store.SetFile(f, []byte("{}"))
+			}
+			err := CheckBundleSize(context.Background(), store, c.bundleSize)
+			if c.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
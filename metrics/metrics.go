@@ -21,3 +21,45 @@ var MetricSet = dmetrics.NewSet()
 var HeadBlockTimeDrift = MetricSet.NewHeadTimeDrift("merger")
 var HeadBlockNumber = MetricSet.NewHeadBlockNumber("merger")
 var AppReadiness = MetricSet.NewAppReadiness("merger")
+
+var HolesFound = MetricSet.NewCounter("merger_holes_found", "Number of distinct holes found in the merged blocks store")
+var HoleBlocksSkipped = MetricSet.NewCounter("merger_hole_blocks_skipped", "Number of blocks in holes skipped over by the merger")
+
+var PreDownloadInFlight = MetricSet.NewGauge("merger_predownload_in_flight", "Number of one-block-files queued or being pre-downloaded")
+var PreDownloadFailures = MetricSet.NewCounter("merger_predownload_failures", "Number of one-block-files that could not be pre-downloaded")
+
+var MergeDuration = MetricSet.NewHistogram("merger_merge_duration", "Time spent merging and uploading a bundle, in seconds")
+var MergedBytes = MetricSet.NewCounter("merger_merged_bytes", "Bytes of the merged objects written to the store, after compression (before it for stores that compress on their own)")
+var LastMergedObjectBytes = MetricSet.NewGauge("merger_last_merged_object_bytes", "Size of the last merged object written to the store, after compression (before it for stores that compress on their own)")
+var MergedBlocks = MetricSet.NewCounter("merger_merged_blocks", "Number of blocks written to merged objects")
+var LastBundleBlocks = MetricSet.NewGauge("merger_last_bundle_blocks", "Number of blocks in the last merged bundle")
+var ForkedBlocks = MetricSet.NewCounter("merger_forked_blocks", "Number of forked blocks found in merged bundles")
+var LastBundleForkedBlocks = MetricSet.NewGauge("merger_last_bundle_forked_blocks", "Number of forked blocks found in the last merged bundle")
+var RetryAttempts = MetricSet.NewCounter("merger_retry_attempts", "Number of operations retried after an error")
+var DeleterQueueDepth = MetricSet.NewGaugeVec("merger_deleter_queue_depth", []string{"store"}, "Number of files waiting for deletion")
+var SkippedDeletions = MetricSet.NewCounterVec("merger_skipped_deletions", []string{"store"}, "Number of files not queued for deletion because the queue was full")
+var FilesWalkedPerPoll = MetricSet.NewGauge("merger_files_walked_per_poll", "Number of one-block-files walked during the last poll")
+var PrunerRuns = MetricSet.NewCounterVec("merger_pruner_runs", []string{"pruner"}, "Number of pruning passes")
+
+var NotificationFailures = MetricSet.NewCounterVec("merger_notification_failures", []string{"notifier"}, "Number of bundle notifications that failed after retries")
+var NotificationsDropped = MetricSet.NewCounterVec("merger_notifications_dropped", []string{"notifier"}, "Number of bundle notifications dropped because the queue was full")
+
+var MergedUploadMismatches = MetricSet.NewCounter("merger_merged_upload_mismatches", "Number of merged files that did not match their one-block-files when read back after upload")
+
+var OneBlockListings = MetricSet.NewCounterVec("merger_one_block_listings", []string{"mode"}, "Number of listings of the one-block-files store, by mode (incremental or full)")
+var OneBlockFilesListed = MetricSet.NewCounterVec("merger_one_block_files_listed", []string{"mode"}, "Number of one-block-files listed, by listing mode")
+var OneBlockFilesYielded = MetricSet.NewCounterVec("merger_one_block_files_yielded", []string{"mode"}, "Number of one-block-files passed to the bundler, by listing mode")
+
+var OneBlockFilesPushed = MetricSet.NewCounter("merger_one_block_files_pushed", "Number of one-block-files discovered by watching the store")
+
+var ReorgDepth = MetricSet.NewHistogram("merger_reorg_depth", "Depth of the forks found in merged bundles, from the fork point to the highest forked block")
+var MaxReorgDepth = MetricSet.NewGauge("merger_max_reorg_depth", "Deepest fork found since the merger started")
+
+var OneBlockConflicts = MetricSet.NewCounter("merger_one_block_conflicts", "Number of blocks whose one-block-files have different content depending on their source")
+var OneBlockSourceConflicts = MetricSet.NewCounterVec("merger_one_block_source_conflicts", []string{"source"}, "Number of blocks where the content of the source's one-block-file was not the one kept")
+
+var SourceBlocksSeen = MetricSet.NewCounterVec("merger_source_blocks_seen", []string{"source"}, "Number of blocks for which the source wrote a one-block-file")
+var SourceCanonicalBlocks = MetricSet.NewCounterVec("merger_source_canonical_blocks", []string{"source"}, "Number of the source's blocks that were merged")
+var SourceForkedBlocks = MetricSet.NewCounterVec("merger_source_forked_blocks", []string{"source"}, "Number of the source's blocks that ended up forked")
+var SourceArrivalDelay = MetricSet.NewHistogramVec("merger_source_arrival_delay", []string{"source"}, "Delay in seconds between the first one-block-file of a block, from any source, and the one of the source")
+var SourceLastBlockNum = MetricSet.NewGaugeVec("merger_source_last_block_num", []string{"source"}, "Highest block number seen in the one-block-files of the source")
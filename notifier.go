@@ -0,0 +1,180 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// BundleNotifier is called after each merged file was successfully written
+type BundleNotifier interface {
+	NotifyBundle(ctx context.Context, bundle *BundleInfo) error
+}
+
+type BundleInfo struct {
+	BaseBlockNum uint64    `json:"base_block_num"`
+	FirstBlock   *BlockRef `json:"first_block,omitempty"` // nil when the bundle is empty
+	LastBlock    *BlockRef `json:"last_block,omitempty"`
+	ObjectName   string    `json:"object_name"`
+	ObjectURL    string    `json:"object_url"`
+	Size         uint64    `json:"size"` // size of the stored object, see objectSizeWriter
+}
+
+type BlockRef struct {
+	Num uint64 `json:"num"`
+	ID  string `json:"id"`
+}
+
+func newBlockRef(obf *bstream.OneBlockFile) *BlockRef {
+	return &BlockRef{Num: obf.Num, ID: obf.ID}
+}
+
+// MultiNotifier calls every notifier, returning the first error
+type MultiNotifier []BundleNotifier
+
+func (m MultiNotifier) NotifyBundle(ctx context.Context, bundle *BundleInfo) (err error) {
+	for _, n := range m {
+		if e := n.NotifyBundle(ctx, bundle); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// WebhookNotifier POSTs the BundleInfo as JSON to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: NotifierTimeout},
+	}
+}
+
+func (n *WebhookNotifier) NotifyBundle(ctx context.Context, bundle *BundleInfo) error {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+// FileNotifier appends the BundleInfo as a JSON line to a local file
+type FileNotifier struct {
+	sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) NotifyBundle(_ context.Context, bundle *BundleInfo) error {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AsyncNotifier queues the notifications and delivers them in the background, with retries,
+// so that a slow or failing notifier never blocks merging. Notifications are dropped when the queue is full.
+type AsyncNotifier struct {
+	name          string
+	notifier      BundleNotifier
+	queue         chan *BundleInfo
+	retryAttempts int
+	retryCooldown time.Duration
+	logger        *zap.Logger
+}
+
+func NewAsyncNotifier(logger *zap.Logger, name string, notifier BundleNotifier, queueSize int, retryAttempts int, retryCooldown time.Duration) *AsyncNotifier {
+	n := &AsyncNotifier{
+		name:          name,
+		notifier:      notifier,
+		queue:         make(chan *BundleInfo, queueSize),
+		retryAttempts: retryAttempts,
+		retryCooldown: retryCooldown,
+		logger:        logger.With(zap.String("notifier", name)),
+	}
+	go n.run()
+	return n
+}
+
+func (n *AsyncNotifier) NotifyBundle(_ context.Context, bundle *BundleInfo) error {
+	select {
+	case n.queue <- bundle:
+		return nil
+	default:
+		metrics.NotificationsDropped.Inc(n.name)
+		return fmt.Errorf("notifier %s queue is full, dropping notification for bundle %d", n.name, bundle.BaseBlockNum)
+	}
+}
+
+func (n *AsyncNotifier) run() {
+	for bundle := range n.queue {
+		err := Retry(n.logger, n.retryAttempts, n.retryCooldown, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), NotifierTimeout)
+			defer cancel()
+			return n.notifier.NotifyBundle(ctx, bundle)
+		})
+		if err != nil {
+			metrics.NotificationFailures.Inc(n.name)
+			n.logger.Warn("cannot notify bundle", zap.Uint64("base_block_num", bundle.BaseBlockNum), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundles.jsonl")
+	n := NewFileNotifier(path)
+
+	require.NoError(t, n.NotifyBundle(context.Background(), &BundleInfo{BaseBlockNum: 100, ObjectName: "0000000100"}))
+	require.NoError(t, n.NotifyBundle(context.Background(), &BundleInfo{
+		BaseBlockNum: 200,
+		FirstBlock:   &BlockRef{Num: 200, ID: "200a"},
+		LastBlock:    &BlockRef{Num: 299, ID: "299a"},
+		ObjectName:   "0000000200",
+		Size:         42,
+	}))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var bundle BundleInfo
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &bundle))
+	assert.Equal(t, uint64(200), bundle.BaseBlockNum)
+	assert.Equal(t, &BlockRef{Num: 299, ID: "299a"}, bundle.LastBlock)
+	assert.Equal(t, uint64(42), bundle.Size)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received BundleInfo
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL)
+	require.NoError(t, n.NotifyBundle(context.Background(), &BundleInfo{BaseBlockNum: 100, ObjectName: "0000000100"}))
+	assert.Equal(t, "0000000100", received.ObjectName)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewWebhookNotifier(failing.URL).NotifyBundle(context.Background(), &BundleInfo{}))
+}
+
+type testNotifier func(ctx context.Context, bundle *BundleInfo) error
+
+func (f testNotifier) NotifyBundle(ctx context.Context, bundle *BundleInfo) error {
+	return f(ctx, bundle)
+}
+
+func TestAsyncNotifier_RetriesWithoutBlocking(t *testing.T) {
+	var calls int32
+	done := make(chan struct{})
+	block := make(chan struct{})
+	n := NewAsyncNotifier(testLogger, "test", testNotifier(func(_ context.Context, _ *BundleInfo) error {
+		<-block
+		switch atomic.AddInt32(&calls, 1) {
+		case 1, 2:
+			return fmt.Errorf("failing")
+		case 4:
+			close(done) // second notification delivered after the first one succeeded
+		}
+		return nil
+	}), 1, 5, time.Millisecond)
+
+	require.NoError(t, n.NotifyBundle(context.Background(), &BundleInfo{BaseBlockNum: 100})) // picked up by the worker
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, n.NotifyBundle(context.Background(), &BundleInfo{BaseBlockNum: 200})) // queued
+	assert.Error(t, n.NotifyBundle(context.Background(), &BundleInfo{BaseBlockNum: 300}), "queue full, dropped instead of blocking")
+
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("notification never delivered")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+type IncrementalIOInterface interface {
+	// WalkNewOneBlockFiles is like WalkOneBlockFiles, but only calls your function for the files that it did not yield before.
+	// Every OneBlockFullRelistInterval, it does a full walk that yields all of them again.
+	WalkNewOneBlockFiles(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error
+
+	// ResetOneBlockListing makes the next WalkNewOneBlockFiles a full walk, call it when the consumer lost its state
+	ResetOneBlockListing()
+}
+
+// oneBlockListing keeps the files already yielded, and the highest block seen, which is used as a cursor
+// to start the next listing from
+type oneBlockListing struct {
+	sync.Mutex
+	seen         map[string]uint64 // filename -> block num
+	highestSeen  uint64
+	lastFullWalk time.Time
+}
+
+func newOneBlockListing() *oneBlockListing {
+	return &oneBlockListing{
+		seen: make(map[string]uint64),
+	}
+}
+
+// markSeen records a file found outside of the listings, by the watcher, so incremental listings do not yield it again
+func (l *oneBlockListing) markSeen(filename string, num uint64) {
+	l.Lock()
+	defer l.Unlock()
+	l.seen[filename] = num
+	if num > l.highestSeen {
+		l.highestSeen = num
+	}
+}
+
+func (s *DStoreIO) ResetOneBlockListing() {
+	s.listing.Lock()
+	defer s.listing.Unlock()
+	s.listing.lastFullWalk = time.Time{}
+}
+
+func (s *DStoreIO) WalkNewOneBlockFiles(ctx context.Context, lowestBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	l := s.listing
+	l.Lock()
+	defer l.Unlock()
+
+	full := time.Since(l.lastFullWalk) >= OneBlockFullRelistInterval
+	mode := "incremental"
+	startBlock := lowestBlock
+	if full {
+		mode = "full"
+		l.seen = make(map[string]uint64)
+		l.highestSeen = 0
+	} else {
+		for filename, num := range l.seen {
+			if num < lowestBlock {
+				delete(l.seen, filename)
+			}
+		}
+		if l.highestSeen > lowestBlock+OneBlockListingLookback {
+			startBlock = l.highestSeen - OneBlockListingLookback
+		}
+	}
+
+	var listed, yielded uint64
+	err := s.oneBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(startBlock), func(filename string) error {
+		if strings.HasSuffix(filename, ".tmp") {
+			return nil
+		}
+		listed++
+		if _, ok := l.seen[filename]; ok {
+			return nil
+		}
+
+		oneBlockFile := bstream.MustNewOneBlockFile(filename)
+		if err := callback(oneBlockFile); err != nil {
+			return err
+		}
+		yielded++
+		l.seen[filename] = oneBlockFile.Num
+		if oneBlockFile.Num > l.highestSeen {
+			l.highestSeen = oneBlockFile.Num
+		}
+		return nil
+	})
+
+	metrics.OneBlockListings.Inc(mode)
+	metrics.OneBlockFilesListed.AddUint64(listed, mode)
+	metrics.OneBlockFilesYielded.AddUint64(yielded, mode)
+	if full && err == nil {
+		l.lastFullWalk = time.Now()
+		s.logger.Debug("full walk of one-block-files done", zap.Uint64("start_block", startBlock), zap.Uint64("listed", listed))
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkNewOneBlockFiles(t *testing.T) {
+	OneBlockFullRelistInterval = time.Hour
+	defer func() { OneBlockFullRelistInterval = time.Minute }()
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.SetFile(block100.CanonicalName+"-suffix", nil)
+	oneBlockStore.SetFile(block101.CanonicalName+"-suffix", nil)
+
+	mio := newDStoreIO(oneBlockStore, dstore.NewMockStore(nil)).(*DStoreIO)
+	walk := func(lowestBlock uint64) (out []uint64) {
+		require.NoError(t, mio.WalkNewOneBlockFiles(context.Background(), lowestBlock, func(obf *bstream.OneBlockFile) error {
+			out = append(out, obf.Num)
+			return nil
+		}))
+		return
+	}
+
+	assert.Equal(t, []uint64{100, 101}, walk(100), "first walk is full")
+	assert.Nil(t, walk(100), "nothing new")
+
+	oneBlockStore.SetFile(block102Final100.CanonicalName+"-suffix", nil)
+	oneBlockStore.SetFile(block103Final101.CanonicalName+"-other", nil) // same block from another source
+	assert.Equal(t, []uint64{102, 103}, walk(100))
+
+	mio.ResetOneBlockListing()
+	assert.Equal(t, []uint64{100, 101, 102, 103}, walk(100))
+	assert.Equal(t, []uint64(nil), walk(102))
+}
+
+func TestWalkNewOneBlockFiles_Lookback(t *testing.T) {
+	OneBlockFullRelistInterval = time.Hour
+	OneBlockListingLookback = 2
+	defer func() {
+		OneBlockFullRelistInterval = time.Minute
+		OneBlockListingLookback = 200
+	}()
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	for _, blk := range []*bstream.OneBlockFile{block100, block105Final103, block106Final104} {
+		oneBlockStore.SetFile(blk.CanonicalName+"-suffix", nil)
+	}
+
+	mio := newDStoreIO(oneBlockStore, dstore.NewMockStore(nil)).(*DStoreIO)
+	var walked []uint64
+	require.NoError(t, mio.WalkNewOneBlockFiles(context.Background(), 100, func(obf *bstream.OneBlockFile) error { return nil }))
+
+	oneBlockStore.SetFile(block101.CanonicalName+"-suffix", nil)         // late, below the cursor
+	oneBlockStore.SetFile(block104Final102.CanonicalName+"-suffix", nil) // late, within the lookback
+	require.NoError(t, mio.WalkNewOneBlockFiles(context.Background(), 100, func(obf *bstream.OneBlockFile) error {
+		walked = append(walked, obf.Num)
+		return nil
+	}))
+	assert.Equal(t, []uint64{104}, walked, "block 101 is left for the next full walk")
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+var ErrWatchNotSupported = errors.New("watching one-block-files is only supported for local (file://) stores on linux")
+
+type OneBlockWatcherIOInterface interface {
+	// SubscribeOneBlockFiles sends the one-block-files as they appear in the store, until the context is canceled.
+	// It returns ErrWatchNotSupported when the store cannot be watched.
+	SubscribeOneBlockFiles(ctx context.Context) (<-chan *bstream.OneBlockFile, error)
+}
+
+// SubscribeOneBlockFiles watches the directory of a local one-block-files store with inotify. On network filesystems,
+// only the files written from this host are seen, the periodic walk catches the others.
+func (s *DStoreIO) SubscribeOneBlockFiles(ctx context.Context) (<-chan *bstream.OneBlockFile, error) {
+	if s.oneBlocksStore.BaseURL().Scheme != "file" {
+		return nil, ErrWatchNotSupported
+	}
+
+	// ObjectPath gives us both the directory and the extension of the store
+	samplePath := s.oneBlocksStore.ObjectPath("x")
+	dir := filepath.Dir(samplePath)
+	extension := strings.TrimPrefix(filepath.Base(samplePath), "x")
+
+	names, err := watchDirectory(ctx, dir, s.logger)
+	if err != nil {
+		return nil, fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	out := make(chan *bstream.OneBlockFile, OneBlockWatchBufferSize)
+	go func() {
+		defer close(out)
+		for name := range names {
+			if !strings.HasSuffix(name, extension) {
+				continue // .tmp files and the likes
+			}
+			filename := strings.TrimSuffix(name, extension)
+			obf, err := bstream.NewOneBlockFile(filename)
+			if err != nil {
+				s.logger.Debug("ignoring file that is not a one-block-file", zap.String("name", name), zap.Error(err))
+				continue
+			}
+			s.listing.markSeen(filename, obf.Num)
+			metrics.OneBlockFilesPushed.Inc()
+			select {
+			case out <- obf:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// handleNewOneBlockFiles feeds the files sent by the watcher to the bundler until the deadline, in batches sorted
+// by block number
+func (m *Merger) handleNewOneBlockFiles(deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		select {
+		case obf, ok := <-m.newOneBlockFiles:
+			if !ok {
+				m.logger.Warn("one-block-files watcher stopped, falling back to polling only")
+				m.newOneBlockFiles = nil
+				<-timer.C
+				return nil
+			}
+			batch := []*bstream.OneBlockFile{obf}
+		drain:
+			for {
+				select {
+				case obf, ok := <-m.newOneBlockFiles:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, obf)
+				default:
+					break drain
+				}
+			}
+
+			sort.Slice(batch, func(i, j int) bool { return batch[i].Num < batch[j].Num })
+			for _, obf := range batch {
+				if obf.Num < m.bundler.baseBlockNum {
+					continue
+				}
+				if err := m.bundler.HandleBlockFile(obf); err != nil {
+					return err
+				}
+			}
+		case <-timer.C:
+			return nil
+		}
+	}
+}
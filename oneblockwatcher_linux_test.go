@@ -0,0 +1,55 @@
+//go:build linux
+
+package merger
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeOneBlockFiles(t *testing.T) {
+	oneBlockStore, err := dstore.NewDBinStore(t.TempDir())
+	require.NoError(t, err)
+
+	mio := newDStoreIO(oneBlockStore, dstore.NewMockStore(nil)).(*DStoreIO)
+	ctx, cancel := context.WithCancel(context.Background())
+	newFiles, err := mio.SubscribeOneBlockFiles(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, oneBlockStore.WriteObject(ctx, block100.CanonicalName+"-suffix", strings.NewReader("data")))
+	select {
+	case obf := <-newFiles:
+		assert.Equal(t, block100.CanonicalName, obf.CanonicalName)
+	case <-time.After(time.Second):
+		t.Fatal("new one-block-file not seen")
+	}
+
+	mio.listing.lastFullWalk = time.Now()
+	var listed []string
+	require.NoError(t, mio.WalkNewOneBlockFiles(ctx, 0, func(obf *bstream.OneBlockFile) error {
+		listed = append(listed, obf.CanonicalName)
+		return nil
+	}))
+	assert.Empty(t, listed, "already sent by the watcher")
+
+	cancel()
+	select {
+	case _, ok := <-newFiles:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed")
+	}
+}
+
+func TestSubscribeOneBlockFiles_NotLocal(t *testing.T) {
+	mio := newDStoreIO(dstore.NewMockStore(nil), dstore.NewMockStore(nil)).(*DStoreIO)
+	_, err := mio.SubscribeOneBlockFiles(context.Background())
+	assert.Equal(t, ErrWatchNotSupported, err)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// preDownloader memoizes the data of the irreversible one-block-files with a fixed number of workers, so it is
+// ready when merging. At most `window` files are queued or downloading, Download blocks when that window is full.
+type preDownloader struct {
+	io     IOInterface
+	window chan struct{}
+	jobs   chan *bstream.OneBlockFile
+	logger *zap.Logger
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lock    sync.Mutex // protects closed and the closing of jobs
+	closed  bool
+	workers sync.WaitGroup
+}
+
+func newPreDownloader(logger *zap.Logger, io IOInterface, parallelism, window int) *preDownloader {
+	if parallelism < 1 {
+		parallelism = ParallelOneBlockDownload
+	}
+	if window < 1 {
+		window = OneBlockDownloadWindow
+	}
+	if window < parallelism {
+		window = parallelism
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &preDownloader{
+		io:     io,
+		window: make(chan struct{}, window),
+		jobs:   make(chan *bstream.OneBlockFile, window),
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	p.workers.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Download queues the one-block-file for download, blocking while the window is full. It does nothing once closed.
+func (p *preDownloader) Download(obf *bstream.OneBlockFile) {
+	select {
+	case p.window <- struct{}{}:
+	case <-p.ctx.Done():
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		<-p.window
+		return
+	}
+	metrics.PreDownloadInFlight.Inc()
+	p.jobs <- obf // never blocks, the window holds a slot for each queued file
+}
+
+// Close cancels the downloads in flight and waits for the workers to stop. Files that were not downloaded are left
+// for the merge to download.
+func (p *preDownloader) Close() {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		p.cancel()
+		close(p.jobs)
+	}
+	p.lock.Unlock()
+	p.workers.Wait()
+}
+
+func (p *preDownloader) work() {
+	defer p.workers.Done()
+	for obf := range p.jobs {
+		if p.ctx.Err() == nil {
+			p.download(obf)
+		}
+		metrics.PreDownloadInFlight.Dec()
+		<-p.window
+	}
+}
+
+func (p *preDownloader) download(obf *bstream.OneBlockFile) {
+	data, err := obf.Data(p.ctx, p.io.DownloadOneBlockFile)
+	if err != nil {
+		if p.ctx.Err() != nil {
+			return // closing
+		}
+		// the data is not memoized, merging will try to download it again
+		metrics.PreDownloadFailures.Inc()
+		p.logger.Warn("cannot pre-download one-block-file", zap.String("canonical_name", obf.CanonicalName), zap.Error(err))
+		return
+	}
+
+	// now that we have the data, might as well read the block time for metrics
+	if time, err := readBlockTime(data); err == nil {
+		metrics.HeadBlockTimeDrift.SetBlockTime(time)
+	}
+}
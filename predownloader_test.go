@@ -0,0 +1,69 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreDownloader_Window(t *testing.T) {
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var downloaded []string
+
+	io := &TestMergerIO{
+		DownloadOneBlockFileFunc: func(_ context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+			<-release
+			lock.Lock()
+			downloaded = append(downloaded, obf.CanonicalName)
+			lock.Unlock()
+			if obf.Num == 101 {
+				return nil, fmt.Errorf("failed")
+			}
+			return []byte(`{"id":"00000001a"}`), nil
+		},
+	}
+
+	p := newPreDownloader(testLogger, io, 1, 2)
+
+	p.Download(&bstream.OneBlockFile{CanonicalName: "100", Num: 100})
+	p.Download(&bstream.OneBlockFile{CanonicalName: "101", Num: 101})
+
+	blocked := make(chan struct{})
+	go func() {
+		p.Download(&bstream.OneBlockFile{CanonicalName: "102", Num: 102})
+		close(blocked)
+	}()
+
+	select {
+	case <-blocked:
+		t.Fatal("download should block while the window is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("download should be unblocked")
+	}
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(downloaded) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"100", "101", "102"}, downloaded)
+
+	p.Close()
+	p.Download(&bstream.OneBlockFile{CanonicalName: "103", Num: 103}) // ignored once closed
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, downloaded, 3)
+}
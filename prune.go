@@ -0,0 +1,94 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+)
+
+// PruneOneBlockFiles deletes the one-block-files from inclusiveLowBlock up to exclusiveHighBlock and waits for the
+// deletions to complete. Unlike the pruner of a running merger, it goes through all of them in a single call.
+func (s *DStoreIO) PruneOneBlockFiles(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) (deleted int, err error) {
+	var toDelete []*bstream.OneBlockFile
+	err = s.WalkOneBlockFiles(ctx, inclusiveLowBlock, func(obf *bstream.OneBlockFile) error {
+		if obf.Num >= exclusiveHighBlock {
+			return ErrStopBlockReached
+		}
+		toDelete = append(toDelete, obf)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrStopBlockReached) {
+		return 0, err
+	}
+
+	for len(toDelete) != 0 {
+		batch := toDelete
+		if len(batch) > DefaultFilesDeleteBatchSize {
+			batch = batch[:DefaultFilesDeleteBatchSize]
+		}
+		toDelete = toDelete[len(batch):]
+
+		if err := s.od.Delete(batch); err != nil {
+			return deleted, err
+		}
+		s.od.Wait()
+		for _, obf := range batch {
+			deleted += len(obf.Filenames)
+		}
+	}
+	metrics.PrunerRuns.Inc("one_block_files")
+	return deleted, nil
+}
+
+// PruneForkedBlocks deletes what DeleteForkedBlocksAsync would between inclusiveLowBoundary and inclusiveHighBoundary,
+// and waits for the deletions to complete
+func (s *ForkAwareDStoreIO) PruneForkedBlocks(ctx context.Context, inclusiveLowBoundary, inclusiveHighBoundary uint64) (deleted int, err error) {
+	filenames, err := s.ForkedFilesToDelete(ctx, inclusiveLowBoundary, inclusiveHighBoundary)
+	if err != nil {
+		return 0, err
+	}
+
+	for len(filenames) != 0 {
+		batch := filenames
+		if len(batch) > DefaultFilesDeleteBatchSize {
+			batch = batch[:DefaultFilesDeleteBatchSize]
+		}
+		filenames = filenames[len(batch):]
+
+		if err := s.forkOd.DeleteFilenames(batch); err != nil {
+			return deleted, err
+		}
+		s.forkOd.Wait()
+		deleted += len(batch)
+	}
+
+	archives, err := s.ForkArchivesToDelete(ctx, inclusiveHighBoundary)
+	if err != nil {
+		return deleted, err
+	}
+	if len(archives) != 0 {
+		if err := s.archiveOd.DeleteFilenames(archives); err != nil {
+			return deleted, err
+		}
+		s.archiveOd.Wait()
+		deleted += len(archives)
+	}
+	metrics.PrunerRuns.Inc("forked_blocks")
+	return deleted, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneOneBlockFiles(t *testing.T) {
+	defer func(size int) { DefaultFilesDeleteBatchSize = size }(DefaultFilesDeleteBatchSize)
+	DefaultFilesDeleteBatchSize = 2
+
+	oneBlocksStore := dstore.NewMockStore(nil)
+	for _, name := range []string{
+		"0000000098-0000000000000098a-0000000000000097a-96-suffix",
+		"0000000099-0000000000000099a-0000000000000098a-97-suffix",
+		"0000000099-0000000000000099a-0000000000000098a-97-other",
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+	} {
+		oneBlocksStore.SetFile(name, nil)
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100).(*DStoreIO)
+	deleted, err := mio.PruneOneBlockFiles(context.Background(), 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+
+	var left []string
+	require.NoError(t, oneBlocksStore.Walk(context.Background(), "", func(filename string) error {
+		left = append(left, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+	}, left)
+}
+
+func TestFindHoles(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.SetFile("0000000100", []byte(`{"id":"0000000000000199a","num":199}`+"\n"))
+	mergedBlocksStore.SetFile("0000000300", []byte(`{"id":"0000000000000399a","num":399}`+"\n"))
+	mergedBlocksStore.SetFile("0000000600", []byte(`{"id":"0000000000000699a","num":699}`+"\n"))
+
+	holes, head, err := FindHoles(context.Background(), newDStoreIO(dstore.NewMockStore(nil), mergedBlocksStore), 100)
+	require.NoError(t, err)
+	assert.Equal(t, []*HoleError{
+		{LowBlockNum: 200, HighBlockNum: 300},
+		{LowBlockNum: 400, HighBlockNum: 600},
+	}, holes)
+	assert.EqualValues(t, 700, head)
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+// Rebundler copies the blocks of a merged blocks store into another merged blocks store, with a different bundle size.
+// Destination bundles that already exist are left untouched, so an interrupted Rebundle can be run again to resume it.
+type Rebundler struct {
+	logger        *zap.Logger
+	srcStore      dstore.Store
+	srcBundleSize uint64
+	dst           *DStoreIO
+}
+
+// RebundleSummary tells what a Rebundle call did, base block nums are those of the destination bundles
+type RebundleSummary struct {
+	Written    []uint64
+	Skipped    []uint64 // already in the destination store
+	Blocks     int      // number of blocks written
+	NextBundle uint64   // first destination bundle that was not written nor skipped
+}
+
+func NewRebundler(logger *zap.Logger, tracer logging.Tracer, srcStore dstore.Store, srcBundleSize uint64, dstStore dstore.Store, dstBundleSize uint64, retryAttempts int, retryCooldown time.Duration) *Rebundler {
+	return &Rebundler{
+		logger:        logger,
+		srcStore:      srcStore,
+		srcBundleSize: srcBundleSize,
+		// blocks are read from srcStore before being given to MergeAndStore, they are never downloaded from the one-block-files store
+		dst: NewDStoreIO(logger, tracer, srcStore, dstStore, nil, retryAttempts, retryCooldown, dstBundleSize).(*DStoreIO),
+	}
+}
+
+// Rebundle writes the destination bundles from the one containing inclusiveLowBlock up to exclusiveHighBlock. With an
+// exclusiveHighBlock of 0, it goes on until the first missing source bundle, otherwise a missing source bundle is an error.
+// Only complete destination bundles are written, and each one is read back to check that it holds the same blocks as the
+// source bundles.
+func (r *Rebundler) Rebundle(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) (*RebundleSummary, error) {
+	dstBundleSize := r.dst.bundleSize
+	summary := &RebundleSummary{NextBundle: toBaseNum(inclusiveLowBlock, dstBundleSize)}
+	inRange := func(base uint64) bool {
+		return exclusiveHighBlock == 0 || base+dstBundleSize <= exclusiveHighBlock
+	}
+
+	// resume after the destination bundles written by a previous run
+	for ; inRange(summary.NextBundle); summary.NextBundle += dstBundleSize {
+		exists, err := r.dst.mergedBlocksStore.FileExists(ctx, fileNameForBlocksBundle(summary.NextBundle))
+		if err != nil {
+			return summary, err
+		}
+		if !exists {
+			break
+		}
+		summary.Skipped = append(summary.Skipped, summary.NextBundle)
+	}
+
+	startBlock := summary.NextBundle
+	var pending []*bstream.OneBlockFile
+	var previous *bstream.OneBlockFile // last block before the next destination bundle, gives the header of empty bundles
+	for srcBase := toBaseNum(startBlock, r.srcBundleSize); inRange(summary.NextBundle); srcBase += r.srcBundleSize {
+		blocks, err := splitMergedFile(ctx, r.srcStore, srcBase)
+		if errors.Is(err, dstore.ErrNotFound) && exclusiveHighBlock == 0 {
+			r.logger.Info("no more source bundles", zap.Uint64("source_base_block_num", srcBase), zap.Int("blocks_not_written", len(pending)))
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		for _, obf := range blocks {
+			if obf.Num < startBlock {
+				previous = obf
+				continue
+			}
+			if len(pending) != 0 && obf.Num <= pending[len(pending)-1].Num {
+				return summary, fmt.Errorf("block %d found after block %d in source bundle %s", obf.Num, pending[len(pending)-1].Num, fileNameForBlocksBundle(srcBase))
+			}
+			pending = append(pending, obf)
+		}
+
+		// write every destination bundle covered by the source bundles read so far
+		for ; summary.NextBundle+dstBundleSize <= srcBase+r.srcBundleSize && inRange(summary.NextBundle); summary.NextBundle += dstBundleSize {
+			var bundle []*bstream.OneBlockFile
+			for len(pending) != 0 && pending[0].Num < summary.NextBundle+dstBundleSize {
+				bundle = append(bundle, pending[0])
+				pending = pending[1:]
+			}
+			if err := r.writeBundle(ctx, summary, summary.NextBundle, bundle, previous); err != nil {
+				return summary, err
+			}
+			if len(bundle) != 0 {
+				previous = bundle[len(bundle)-1]
+			}
+		}
+	}
+	return summary, nil
+}
+
+// writeBundle writes an empty bundle like the merger does when skipping a bundle: with the previous block, which
+// MergeAndStore leaves out, for the dbin header
+func (r *Rebundler) writeBundle(ctx context.Context, summary *RebundleSummary, base uint64, bundle []*bstream.OneBlockFile, previous *bstream.OneBlockFile) error {
+	exists, err := r.dst.mergedBlocksStore.FileExists(ctx, fileNameForBlocksBundle(base))
+	if err != nil {
+		return err
+	}
+	if exists {
+		summary.Skipped = append(summary.Skipped, base)
+		return nil
+	}
+	toMerge := bundle
+	if len(bundle) == 0 {
+		if previous == nil {
+			return fmt.Errorf("no block before empty destination bundle %s to take the dbin header from", fileNameForBlocksBundle(base))
+		}
+		toMerge = []*bstream.OneBlockFile{previous}
+	}
+
+	if err := r.dst.MergeAndStore(ctx, base, toMerge); err != nil {
+		return err
+	}
+	if err := r.dst.checkMergedObject(ctx, fileNameForBlocksBundle(base), bundle); err != nil {
+		return fmt.Errorf("verifying destination bundle: %w", err)
+	}
+
+	summary.Written = append(summary.Written, base)
+	summary.Blocks += len(bundle)
+	r.logger.Debug("rebundled", zap.Uint64("base_block_num", base), zap.Int("blocks", len(bundle)))
+	return nil
+}
+
+// splitMergedFile reads the merged file at base as one-block-files, their data being the dbin header followed by a
+// single block, like the files written by extractors and as BundleReader expects them
+func splitMergedFile(ctx context.Context, store dstore.Store, base uint64) (out []*bstream.OneBlockFile, err error) {
+	subCtx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
+	defer cancel()
+
+	filename := fileNameForBlocksBundle(base)
+	exists, err := store.FileExists(subCtx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("merged file %s: %w", filename, dstore.ErrNotFound)
+	}
+	reader, err := store.OpenObject(subCtx, filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening merged file %s: %w", filename, err)
+	}
+	defer reader.Close()
+
+	header := make([]byte, bstream.GetBlockWriterHeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("reading header of merged file %s: %w", filename, err)
+	}
+
+	for {
+		length := make([]byte, 4)
+		if _, err := io.ReadFull(reader, length); err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return nil, fmt.Errorf("reading merged file %s: %w", filename, err)
+		}
+		data := make([]byte, len(header)+len(length)+int(binary.BigEndian.Uint32(length)))
+		copy(data, header)
+		copy(data[len(header):], length)
+		if _, err := io.ReadFull(reader, data[len(header)+len(length):]); err != nil {
+			return nil, fmt.Errorf("reading merged file %s: %w", filename, err)
+		}
+
+		block, err := readBlock(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading merged file %s: %w", filename, err)
+		}
+		out = append(out, &bstream.OneBlockFile{
+			CanonicalName: filename,
+			Filenames:     map[string]bool{filename: true},
+			ID:            bstream.TruncateBlockID(block.Id),
+			Num:           block.Number,
+			LibNum:        block.LibNum,
+			PreviousID:    bstream.TruncateBlockID(block.PreviousId),
+			MemoizeData:   data,
+		})
+	}
+}
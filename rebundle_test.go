@@ -0,0 +1,136 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	pbbstream "github.com/streamingfast/pbgo/sf/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDBinBlock(t *testing.T, num uint64) *bstream.Block {
+	ts, err := ptypes.TimestampProto(time.Unix(int64(num), 0))
+	require.NoError(t, err)
+	block, err := bstream.NewBlockFromProto(&pbbstream.Block{
+		Id:            fmt.Sprintf("%08xaa", num),
+		PreviousId:    fmt.Sprintf("%08xaa", num-1),
+		Number:        num,
+		LibNum:        num - 1,
+		Timestamp:     ts,
+		PayloadBuffer: []byte(fmt.Sprintf("payload %d", num)),
+	})
+	require.NoError(t, err)
+	return block
+}
+
+func writeTestBundle(t *testing.T, store *dstore.MockStore, base, bundleSize uint64) {
+	buf := &bytes.Buffer{}
+	writer, err := bstream.NewDBinBlockWriter(buf, "TST", 1)
+	require.NoError(t, err)
+	for num := base; num < base+bundleSize; num++ {
+		if num == 0 {
+			continue
+		}
+		require.NoError(t, writer.Write(testDBinBlock(t, num)))
+	}
+	store.SetFile(fileNameForBlocksBundle(base), buf.Bytes())
+}
+
+func bundleBlockNums(t *testing.T, store dstore.Store, base uint64) (out []uint64) {
+	blocks, err := NewDStoreIO(testLogger, testTracer, store, store, nil, 0, 0, 100).(*DStoreIO).ReadBundle(context.Background(), base)
+	require.NoError(t, err)
+	for _, block := range blocks {
+		out = append(out, block.Number)
+	}
+	return
+}
+
+func TestRebundle(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 10
+	ctx := context.Background()
+
+	src := dstore.NewMockStore(nil)
+	for base := uint64(0); base < 50; base += 10 {
+		writeTestBundle(t, src, base, 10)
+	}
+
+	t.Run("bigger bundles", func(t *testing.T) {
+		dst := dstore.NewMockStore(nil)
+		summary, err := NewRebundler(testLogger, testTracer, src, 10, dst, 20, 0, 0).Rebundle(ctx, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{0, 20}, summary.Written, "40-59 is incomplete")
+		assert.Equal(t, 39, summary.Blocks)
+		assert.EqualValues(t, 40, summary.NextBundle)
+
+		assert.Len(t, bundleBlockNums(t, dst, 0), 19)
+		assert.Equal(t, uint64(20), bundleBlockNums(t, dst, 20)[0])
+		assert.Equal(t, uint64(39), bundleBlockNums(t, dst, 20)[19])
+
+		// resuming
+		writeTestBundle(t, src, 50, 10)
+		summary, err = NewRebundler(testLogger, testTracer, src, 10, dst, 20, 0, 0).Rebundle(ctx, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{0, 20}, summary.Skipped)
+		assert.Equal(t, []uint64{40}, summary.Written)
+		assert.Equal(t, 20, summary.Blocks)
+		src.DeleteObject(ctx, fileNameForBlocksBundle(50))
+	})
+
+	t.Run("smaller bundles with range", func(t *testing.T) {
+		dst := dstore.NewMockStore(nil)
+		summary, err := NewRebundler(testLogger, testTracer, src, 10, dst, 5, 0, 0).Rebundle(ctx, 12, 30)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{10, 15, 20, 25}, summary.Written)
+		assert.Equal(t, 20, summary.Blocks)
+		assert.Equal(t, []uint64{15, 16, 17, 18, 19}, bundleBlockNums(t, dst, 15))
+	})
+
+	t.Run("missing source bundle", func(t *testing.T) {
+		dst := dstore.NewMockStore(nil)
+		_, err := NewRebundler(testLogger, testTracer, src, 10, dst, 20, 0, 0).Rebundle(ctx, 0, 80)
+		require.ErrorIs(t, err, dstore.ErrNotFound)
+	})
+}
+
+func TestRebundleEmptyBundles(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 10
+	ctx := context.Background()
+
+	src := dstore.NewMockStore(nil)
+	writeTestBundle(t, src, 0, 10)
+	buf := &bytes.Buffer{}
+	_, err := bstream.NewDBinBlockWriter(buf, "TST", 1)
+	require.NoError(t, err)
+	src.SetFile(fileNameForBlocksBundle(10), buf.Bytes()) // written by the merger when skipping a bundle
+	writeTestBundle(t, src, 20, 10)
+
+	dst := dstore.NewMockStore(nil)
+	summary, err := NewRebundler(testLogger, testTracer, src, 10, dst, 5, 0, 0).Rebundle(ctx, 0, 30)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 5, 10, 15, 20, 25}, summary.Written)
+	assert.Equal(t, 19, summary.Blocks)
+	assert.Empty(t, bundleBlockNums(t, dst, 10))
+	assert.Empty(t, bundleBlockNums(t, dst, 15))
+	assert.Equal(t, []uint64{20, 21, 22, 23, 24}, bundleBlockNums(t, dst, 20))
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sort"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// Fork is a branch of forked blocks, abandoned in favor of the irreversible chain
+type Fork struct {
+	ForkPointNum   uint64 // block num of the parent of the branch's first block
+	ForkPointID    string
+	HighestNum     uint64
+	HighestID      string
+	Depth          uint64 // HighestNum - ForkPointNum
+	NumberOfBlocks int
+}
+
+// findForks groups forked blocks into branches by following their parent IDs. The fork point of a branch is the parent of its
+// lowest block, its number is taken from the irreversible blocks when known. A branch whose lowest block is the child of a block
+// in `previous` (forked block ID -> fork, as returned for the previous bundle) continues that fork instead, so that a branch
+// crossing a bundle boundary keeps its fork point. The returned map holds the forked blocks of this call, for the next bundle.
+func findForks(forkedBlocks, irreversibleBlocks []*bstream.OneBlockFile, previous map[string]*Fork) ([]*Fork, map[string]*Fork) {
+	byID := make(map[string]*bstream.OneBlockFile, len(forkedBlocks))
+	for _, block := range forkedBlocks {
+		byID[block.ID] = block
+	}
+	canonicalNums := make(map[string]uint64, len(irreversibleBlocks))
+	for _, block := range irreversibleBlocks {
+		canonicalNums[block.ID] = block.Num
+	}
+
+	roots := make(map[string]*bstream.OneBlockFile) // block ID -> first forked block of its branch
+	var rootOf func(block *bstream.OneBlockFile) *bstream.OneBlockFile
+	rootOf = func(block *bstream.OneBlockFile) *bstream.OneBlockFile {
+		if root, ok := roots[block.ID]; ok {
+			return root
+		}
+		root := block
+		if parent, ok := byID[block.PreviousID]; ok && parent.Num < block.Num {
+			root = rootOf(parent)
+		}
+		roots[block.ID] = root
+		return root
+	}
+
+	forks := make(map[*bstream.OneBlockFile]*Fork)
+	byBlockID := make(map[string]*Fork, len(forkedBlocks))
+	var out []*Fork
+	for _, block := range forkedBlocks {
+		root := rootOf(block)
+		fork, ok := forks[root]
+		if !ok {
+			fork, ok = previous[root.PreviousID]
+			if !ok {
+				forkPointNum, known := canonicalNums[root.PreviousID]
+				if !known && root.Num > 0 {
+					forkPointNum = root.Num - 1
+				}
+				fork = &Fork{ForkPointNum: forkPointNum, ForkPointID: root.PreviousID}
+			}
+			forks[root] = fork
+			if !containsFork(out, fork) {
+				out = append(out, fork)
+			}
+		}
+		byBlockID[block.ID] = fork
+		fork.NumberOfBlocks++
+		if block.Num >= fork.HighestNum {
+			fork.HighestNum = block.Num
+			fork.HighestID = block.ID
+		}
+	}
+
+	for _, fork := range out {
+		fork.Depth = fork.HighestNum - fork.ForkPointNum
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ForkPointNum < out[j].ForkPointNum })
+	return out, byBlockID
+}
+
+func containsFork(forks []*Fork, fork *Fork) bool {
+	for _, f := range forks {
+		if f == fork {
+			return true
+		}
+	}
+	return false
+}
+
+// recordForks reports the forks of the previous bundle that the forked blocks of this bundle do not continue: a branch
+// is only reported once complete, with its full depth.
+func (b *Bundler) recordForks(forkedBlocks, irreversibleBlocks []*bstream.OneBlockFile) {
+	forks, openForks := findForks(forkedBlocks, irreversibleBlocks, b.openForks)
+
+	var closed []*Fork
+	for _, fork := range b.openForks {
+		if !containsFork(forks, fork) && !containsFork(closed, fork) {
+			closed = append(closed, fork)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].ForkPointNum < closed[j].ForkPointNum })
+	b.openForks = openForks
+
+	for _, fork := range closed {
+		metrics.ReorgDepth.ObserveInt64(int64(fork.Depth))
+		if fork.Depth > b.maxReorgDepth {
+			b.maxReorgDepth = fork.Depth
+			metrics.MaxReorgDepth.SetUint64(fork.Depth)
+		}
+
+		if b.reorgDepthWarningThreshold != 0 && fork.Depth >= b.reorgDepthWarningThreshold {
+			b.logger.Warn("deep reorg detected",
+				zap.Uint64("depth", fork.Depth),
+				zap.Uint64("fork_point_num", fork.ForkPointNum),
+				zap.String("fork_point_id", fork.ForkPointID),
+				zap.Uint64("highest_forked_num", fork.HighestNum),
+				zap.String("highest_forked_id", fork.HighestID),
+				zap.Int("forked_blocks", fork.NumberOfBlocks),
+			)
+		}
+	}
+}
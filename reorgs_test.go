@@ -0,0 +1,69 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindForks(t *testing.T) {
+	forked := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000103-0000000000000103b-0000000000000102b-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000102-0000000000000102b-0000000000000101b-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000104-0000000000000104c-0000000000000103a-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000107-0000000000000107d-0000000000000106z-99-suffix"), // parent unknown
+	}
+	irreversible := []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102}
+
+	forks, _ := findForks(forked, irreversible, nil)
+	require.Len(t, forks, 3)
+
+	assert.Equal(t, &Fork{ForkPointNum: 100, ForkPointID: "0000000000000100a", HighestNum: 103, HighestID: "0000000000000103b", Depth: 3, NumberOfBlocks: 3}, forks[0])
+	assert.Equal(t, &Fork{ForkPointNum: 103, ForkPointID: "0000000000000103a", HighestNum: 104, HighestID: "0000000000000104c", Depth: 1, NumberOfBlocks: 1}, forks[1])
+	assert.Equal(t, &Fork{ForkPointNum: 106, ForkPointID: "0000000000000106z", HighestNum: 107, HighestID: "0000000000000107d", Depth: 1, NumberOfBlocks: 1}, forks[2])
+}
+
+func TestFindForksAcrossBundles(t *testing.T) {
+	irreversible := []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000197-0000000000000197a-0000000000000196a-99-suffix"),
+	}
+	forks, open := findForks([]*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000198-0000000000000198b-0000000000000197a-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000199-0000000000000199b-0000000000000198b-99-suffix"),
+	}, irreversible, nil)
+	require.Len(t, forks, 1)
+	assert.Equal(t, &Fork{ForkPointNum: 197, ForkPointID: "0000000000000197a", HighestNum: 199, HighestID: "0000000000000199b", Depth: 2, NumberOfBlocks: 2}, forks[0])
+
+	forks, _ = findForks([]*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000200-0000000000000200b-0000000000000199b-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000201-0000000000000201b-0000000000000200b-99-suffix"),
+	}, nil, open)
+	require.Len(t, forks, 1)
+	assert.Equal(t, &Fork{ForkPointNum: 197, ForkPointID: "0000000000000197a", HighestNum: 201, HighestID: "0000000000000201b", Depth: 4, NumberOfBlocks: 4}, forks[0])
+}
+
+func TestBundlerRecordForksAcrossBundles(t *testing.T) {
+	b := NewBundler(testLogger, 100, 0, 100, 100, nil)
+	defer b.Close()
+
+	b.recordForks([]*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000198-0000000000000198b-0000000000000197a-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000199-0000000000000199b-0000000000000198b-99-suffix"),
+	}, []*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000197-0000000000000197a-0000000000000196a-99-suffix"),
+	})
+	assert.EqualValues(t, 0, b.maxReorgDepth, "the fork may continue in the next bundle")
+
+	b.recordForks([]*bstream.OneBlockFile{
+		bstream.MustNewOneBlockFile("0000000200-0000000000000200b-0000000000000199b-99-suffix"),
+		bstream.MustNewOneBlockFile("0000000201-0000000000000201b-0000000000000200b-99-suffix"),
+	}, nil)
+	assert.EqualValues(t, 0, b.maxReorgDepth)
+
+	b.recordForks(nil, nil)
+	assert.EqualValues(t, 4, b.maxReorgDepth)
+	assert.Empty(t, b.openForks)
+}
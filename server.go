@@ -1,8 +1,15 @@
 package merger
 
 import (
+	"fmt"
+
+	"github.com/streamingfast/bstream"
 	dgrpcfactory "github.com/streamingfast/dgrpc/server/factory"
+	pbmerger "github.com/streamingfast/pbgo/sf/merger/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 func (m *Merger) startGRPCServer() {
@@ -14,8 +21,81 @@ func (m *Merger) startGRPCServer() {
 		gs.Shutdown(0)
 	})
 	pbhealth.RegisterHealthServer(gs.ServiceRegistrar(), m)
+	pbmerger.RegisterMergerServer(gs.ServiceRegistrar(), m)
 	m.logger.Info("server registered")
 
 	go gs.Launch(m.grpcListenAddr)
 
 }
+
+// PreMergedBlocks sends the irreversible blocks accumulated in the bundler (not merged yet), starting at
+// `LowBlockNum`, then keeps sending the new ones as they come in. If `HighBlockID` is set, the stream
+// ends after that block was sent.
+func (m *Merger) PreMergedBlocks(req *pbmerger.Request, server pbmerger.Merger_PreMergedBlocksServer) error {
+	blocks, sub := m.bundler.Subscribe(PreMergedBlocksBufferSize)
+	defer m.bundler.Unsubscribe(sub)
+
+	if len(blocks) == 0 || req.LowBlockNum < blocks[0].Num {
+		return status.Errorf(codes.NotFound, "cannot find requested low block num %d in bundler (%s)", req.LowBlockNum, m.bundler)
+	}
+
+	logger := m.logger.With(zap.Uint64("low_block_num", req.LowBlockNum), zap.String("high_block_id", req.HighBlockID))
+	logger.Info("sending pre-merged blocks", zap.Int("accumulated_blocks", len(blocks)))
+
+	highBlockID := bstream.TruncateBlockID(req.HighBlockID)
+	send := func(obf *bstream.OneBlockFile) (done bool, err error) {
+		if obf.Num < req.LowBlockNum {
+			return false, nil
+		}
+		data, err := obf.Data(server.Context(), m.io.DownloadOneBlockFile)
+		if err != nil {
+			return false, fmt.Errorf("cannot get data from one-block-file %s: %w", obf.CanonicalName, err)
+		}
+		blk, err := readBlock(data)
+		if err != nil {
+			return false, fmt.Errorf("cannot read block from one-block-file %s: %w", obf.CanonicalName, err)
+		}
+		blkProto, err := blk.ToProto()
+		if err != nil {
+			return false, fmt.Errorf("cannot convert block %s to proto: %w", blk, err)
+		}
+		if err := server.Send(&pbmerger.Response{Found: true, Block: blkProto}); err != nil {
+			return false, err
+		}
+		return highBlockID != "" && obf.ID == highBlockID, nil
+	}
+
+	for _, obf := range blocks {
+		done, err := send(obf)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+
+	for {
+		select {
+		case <-m.Terminating():
+			return status.Error(codes.Unavailable, "merger is shutting down")
+		case <-server.Context().Done():
+			return server.Context().Err()
+		case obf, ok := <-sub.Blocks():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					logger.Info("pre-merged blocks subscription ended", zap.Error(err))
+					return status.Errorf(codes.Aborted, "pre-merged blocks subscription ended: %s", err)
+				}
+				return nil
+			}
+			done, err := send(obf)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// sourceVariant is the content of a block as written by one or more sources
+type sourceVariant struct {
+	sha256  string
+	data    []byte
+	sources []string
+}
+
+func (v *sourceVariant) String() string {
+	return fmt.Sprintf("%s from %s", v.sha256[:12], strings.Join(v.sources, ","))
+}
+
+// downloadAllSources downloads the one-block-file of every source of a block and compares their content. When they
+// differ, the content written by the most sources is kept, ties going to the source that sorts first, and the sources
+// that disagree are logged and counted.
+func (s *DStoreIO) downloadAllSources(ctx context.Context, oneBlockFile *bstream.OneBlockFile) (data []byte, err error) {
+	var filenames []string
+	for filename := range oneBlockFile.Filenames {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames) // same block, so this sorts them by source
+
+	var variants []*sourceVariant
+	for _, filename := range filenames {
+		content, downloadErr := s.downloadOneBlockFilename(ctx, filename)
+		if downloadErr != nil {
+			s.logger.Debug("cannot download one block to compare it", zap.String("file_name", filename), zap.Error(downloadErr))
+			err = downloadErr
+			continue
+		}
+
+		source := oneBlockFileSource(filename)
+		if source == "" {
+			source = filename
+		}
+		checksum := sha256.Sum256(content)
+		hash := hex.EncodeToString(checksum[:])
+
+		var variant *sourceVariant
+		for _, v := range variants {
+			if v.sha256 == hash {
+				variant = v
+				break
+			}
+		}
+		if variant == nil {
+			variant = &sourceVariant{sha256: hash, data: content}
+			variants = append(variants, variant)
+		}
+		variant.sources = append(variant.sources, source)
+	}
+	if len(variants) == 0 {
+		return nil, err
+	}
+
+	kept := variants[0]
+	for _, v := range variants[1:] {
+		if len(v.sources) > len(kept.sources) {
+			kept = v
+		}
+	}
+	if len(variants) > 1 {
+		s.reportSourceConflict(oneBlockFile, variants, kept)
+	}
+	return kept.data, nil
+}
+
+func (s *DStoreIO) reportSourceConflict(oneBlockFile *bstream.OneBlockFile, variants []*sourceVariant, kept *sourceVariant) {
+	metrics.OneBlockConflicts.Inc()
+
+	var described, disagreeing []string
+	for _, v := range variants {
+		described = append(described, v.String())
+		if v == kept {
+			continue
+		}
+		for _, source := range v.sources {
+			metrics.OneBlockSourceConflicts.Inc(source)
+			disagreeing = append(disagreeing, source)
+		}
+	}
+
+	s.logger.Warn("sources disagree on the content of a block",
+		zap.Uint64("block_num", oneBlockFile.Num),
+		zap.String("block_id", oneBlockFile.ID),
+		zap.Strings("kept_sources", kept.sources),
+		zap.Strings("disagreeing_sources", disagreeing),
+		zap.Strings("variants", described),
+	)
+}
+
+func (s *DStoreIO) downloadOneBlockFilename(ctx context.Context, filename string) ([]byte, error) {
+	s.logger.Debug("downloading one block", zap.String("file_name", filename))
+	out, err := s.oneBlocksStore.OpenObject(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	return ioutil.ReadAll(out)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadOneBlockFileCompareSources(t *testing.T) {
+	const prefix = "0000000100-0000000000000100a-0000000000000099a-98-"
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{"agreeing", map[string]string{"a": "good", "b": "good"}, "good"},
+		{"majority wins", map[string]string{"a": "bad", "b": "good", "c": "good"}, "good"},
+		{"tie goes to first source", map[string]string{"b": "bad", "a": "good"}, "good"},
+		{"missing variant", map[string]string{"a": "", "b": "good"}, "good"},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			oneBlocksStore := dstore.NewMockStore(nil)
+			obf := &bstream.OneBlockFile{Num: 100, ID: "0000000000000100a", Filenames: map[string]bool{}}
+			for source, content := range c.files {
+				obf.Filenames[prefix+source] = true
+				if content != "" {
+					oneBlocksStore.SetFile(prefix+source, []byte(content))
+				}
+			}
+
+			mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithOneBlockSourcesComparison())
+			data, err := mio.DownloadOneBlockFile(context.Background(), obf)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, string(data))
+		})
+	}
+}
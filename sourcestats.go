@@ -0,0 +1,167 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
+)
+
+// SourceStats describes the one-block-files written by a source (the SOURCE part of their names), as seen by the merger.
+// Arrival delays are measured when the merger discovers the files, so they are only as precise as the polling, or
+// watching, of the one-block-files store.
+type SourceStats struct {
+	Source          string `json:"source"`
+	BlocksSeen      uint64 `json:"blocks_seen"`
+	CanonicalBlocks uint64 `json:"canonical_blocks"` // its blocks that ended up in a merged file
+	ForkedBlocks    uint64 `json:"forked_blocks"`
+	FirstArrivals   uint64 `json:"first_arrivals"` // blocks for which its file was the first one seen
+
+	// delay between the first file of a block, from any source, and the file of this source
+	AverageArrivalDelaySeconds float64 `json:"average_arrival_delay_seconds"`
+	MaxArrivalDelaySeconds     float64 `json:"max_arrival_delay_seconds"`
+
+	LastBlockNum uint64    `json:"last_block_num"`
+	LastSeenAt   time.Time `json:"last_seen_at"`
+
+	totalArrivalDelay time.Duration
+}
+
+// blockArrivals are the sources of a block seen so far, until it is bundled
+type blockArrivals struct {
+	num     uint64
+	first   time.Time
+	sources map[string]bool
+}
+
+type sourceStats struct {
+	sync.Mutex
+	sources map[string]*SourceStats
+	blocks  map[string]*blockArrivals // by canonical name
+}
+
+func newSourceStats() *sourceStats {
+	return &sourceStats{
+		sources: make(map[string]*SourceStats),
+		blocks:  make(map[string]*blockArrivals),
+	}
+}
+
+func (s *sourceStats) source(name string) *SourceStats {
+	stats, ok := s.sources[name]
+	if !ok {
+		stats = &SourceStats{Source: name}
+		s.sources[name] = stats
+	}
+	return stats
+}
+
+// fileSeen records the sources of a one-block-file the first time each of them is seen for that block
+func (s *sourceStats) fileSeen(obf *bstream.OneBlockFile, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	block, ok := s.blocks[obf.CanonicalName]
+	if !ok {
+		block = &blockArrivals{num: obf.Num, first: now, sources: make(map[string]bool)}
+		s.blocks[obf.CanonicalName] = block
+	}
+
+	for filename := range obf.Filenames {
+		source := oneBlockFileSource(filename)
+		if source == "" || block.sources[source] {
+			continue
+		}
+		block.sources[source] = true
+
+		delay := now.Sub(block.first)
+		stats := s.source(source)
+		stats.BlocksSeen++
+		if len(block.sources) == 1 {
+			stats.FirstArrivals++
+		}
+		stats.totalArrivalDelay += delay
+		if delay.Seconds() > stats.MaxArrivalDelaySeconds {
+			stats.MaxArrivalDelaySeconds = delay.Seconds()
+		}
+		if obf.Num > stats.LastBlockNum {
+			stats.LastBlockNum = obf.Num
+			metrics.SourceLastBlockNum.SetUint64(obf.Num, source)
+		}
+		stats.LastSeenAt = now
+
+		metrics.SourceBlocksSeen.Inc(source)
+		metrics.SourceArrivalDelay.ObserveDuration(delay, source)
+	}
+}
+
+// bundled attributes the blocks of a bundle to their sources, then forgets about every block below exclusiveHighBlockNum
+func (s *sourceStats) bundled(baseBlockNum, exclusiveHighBlockNum uint64, canonical, forked []*bstream.OneBlockFile) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, obf := range canonical {
+		if obf.Num < baseBlockNum {
+			continue // last block of the previous bundle, already counted
+		}
+		if block, ok := s.blocks[obf.CanonicalName]; ok {
+			for source := range block.sources {
+				s.source(source).CanonicalBlocks++
+				metrics.SourceCanonicalBlocks.Inc(source)
+			}
+		}
+	}
+	for _, obf := range forked {
+		if block, ok := s.blocks[obf.CanonicalName]; ok {
+			for source := range block.sources {
+				s.source(source).ForkedBlocks++
+				metrics.SourceForkedBlocks.Inc(source)
+			}
+		}
+	}
+
+	for name, block := range s.blocks {
+		if block.num < exclusiveHighBlockNum {
+			delete(s.blocks, name)
+		}
+	}
+}
+
+// reset forgets about the blocks not bundled yet, the totals of each source are kept
+func (s *sourceStats) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.blocks = make(map[string]*blockArrivals)
+}
+
+func (s *sourceStats) snapshot() []*SourceStats {
+	s.Lock()
+	defer s.Unlock()
+
+	out := make([]*SourceStats, 0, len(s.sources))
+	for _, stats := range s.sources {
+		copied := *stats
+		if stats.BlocksSeen != 0 {
+			copied.AverageArrivalDelaySeconds = stats.totalArrivalDelay.Seconds() / float64(stats.BlocksSeen)
+		}
+		out = append(out, &copied)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Source < out[j].Source })
+	return out
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceStats(t *testing.T) {
+	stats := newSourceStats()
+	t0 := time.Now()
+
+	block100a := bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-a")
+	block100b := bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-b")
+	block101a := bstream.MustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-a")
+	block101b := bstream.MustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-b")
+	forked101c := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-c")
+	block200a := bstream.MustNewOneBlockFile("0000000200-0000000000000200a-0000000000000199a-198-a")
+
+	stats.fileSeen(block100a, t0)
+	stats.fileSeen(block100b, t0.Add(2*time.Second))
+	stats.fileSeen(block100a, t0.Add(3*time.Second)) // seen again on a full listing
+	stats.fileSeen(block101b, t0.Add(4*time.Second))
+	stats.fileSeen(block101a, t0.Add(5*time.Second))
+	stats.fileSeen(forked101c, t0.Add(6*time.Second))
+	stats.fileSeen(block200a, t0.Add(7*time.Second))
+
+	stats.bundled(100, 200, []*bstream.OneBlockFile{block100a, block101a}, []*bstream.OneBlockFile{forked101c})
+	assert.Len(t, stats.blocks, 1, "only block 200 is kept")
+
+	snapshot := stats.snapshot()
+	require.Len(t, snapshot, 3)
+
+	a, b, c := snapshot[0], snapshot[1], snapshot[2]
+	assert.Equal(t, "a", a.Source)
+	assert.EqualValues(t, 3, a.BlocksSeen)
+	assert.EqualValues(t, 2, a.CanonicalBlocks)
+	assert.EqualValues(t, 0, a.ForkedBlocks)
+	assert.EqualValues(t, 2, a.FirstArrivals)
+	assert.Equal(t, 1.0, a.MaxArrivalDelaySeconds)
+	assert.InDelta(t, 1.0/3, a.AverageArrivalDelaySeconds, 0.001)
+	assert.EqualValues(t, 200, a.LastBlockNum)
+
+	assert.Equal(t, "b", b.Source)
+	assert.EqualValues(t, 2, b.CanonicalBlocks)
+	assert.EqualValues(t, 1, b.FirstArrivals)
+	assert.Equal(t, 2.0, b.MaxArrivalDelaySeconds)
+
+	assert.Equal(t, "c", c.Source)
+	assert.EqualValues(t, 0, c.CanonicalBlocks)
+	assert.EqualValues(t, 1, c.ForkedBlocks)
+	assert.EqualValues(t, 1, c.FirstArrivals, "only source of its fork")
+}
+
+func TestServeSourceStats(t *testing.T) {
+	m := NewMerger(testLogger, "", nil, 1, 100, 100, time.Second, time.Second, 0)
+	defer m.Shutdown(nil)
+	m.bundler.sourceStats.fileSeen(bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-a"), time.Now())
+
+	rec := httptest.NewRecorder()
+	m.serveSourceStats(rec, httptest.NewRequest(http.MethodGet, "/status/sources", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var out []*SourceStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	require.Len(t, out, 1)
+	assert.Equal(t, "a", out[0].Source)
+	assert.EqualValues(t, 1, out[0].BlocksSeen)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// State is the bundler checkpoint, written after each merged bundle
+type State struct {
+	BaseBlockNum     uint64 `json:"base_block_num"`
+	LIBNum           uint64 `json:"lib_num"`
+	LIBID            string `json:"lib_id"`
+	LastMergedBundle uint64 `json:"last_merged_bundle"`
+}
+
+func (s *State) LIB() bstream.BlockRef {
+	return bstream.NewBlockRef(s.LIBID, s.LIBNum)
+}
+
+// StateFile persists the State to a local file or to any dstore object
+type StateFile struct {
+	store    dstore.Store
+	filename string
+}
+
+// NewStateFile accepts a local path or a dstore URL pointing to the file itself, ex: gs://bucket/merger/state.json
+func NewStateFile(fileURL string) (*StateFile, error) {
+	store, filename, err := dstore.NewStoreFromURL(fileURL, dstore.AllowOverwrite())
+	if err != nil {
+		return nil, fmt.Errorf("cannot create store for state file %q: %w", fileURL, err)
+	}
+	return &StateFile{
+		store:    store,
+		filename: filename,
+	}, nil
+}
+
+// Load returns a nil State if the file does not exist yet
+func (f *StateFile) Load(ctx context.Context) (*State, error) {
+	exists, err := f.store.FileExists(ctx, f.filename)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	reader, err := f.store.OpenObject(ctx, f.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("cannot decode state file: %w", err)
+	}
+	return state, nil
+}
+
+func (f *StateFile) Save(ctx context.Context, state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return f.store.WriteObject(ctx, f.filename, bytes.NewReader(data))
+}
+
+// resumeFromState resets the bundler to the checkpoint from the state file, if it agrees with the merged blocks store
+func (m *Merger) resumeFromState(ctx context.Context) error {
+	state, err := m.stateFile.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot load state file: %w", err)
+	}
+	if state == nil {
+		return fmt.Errorf("no state file yet")
+	}
+	if state.BaseBlockNum < m.bundler.baseBlockNum {
+		return fmt.Errorf("state base block num %d is below first streamable block bundle %d", state.BaseBlockNum, m.bundler.baseBlockNum)
+	}
+
+	base, lib, err := m.io.NextBundle(ctx, state.LastMergedBundle)
+	if err != nil {
+		return fmt.Errorf("cannot verify state against merged blocks: %w", err)
+	}
+	if base < state.BaseBlockNum {
+		return fmt.Errorf("state expects merged files up to bundle %d, but merged blocks store stops at %d", state.LastMergedBundle, base)
+	}
+	if lib == nil {
+		return fmt.Errorf("cannot find last merged bundle %d", state.LastMergedBundle)
+	}
+	if base == state.BaseBlockNum && (lib.Num() != state.LIBNum || bstream.TruncateBlockID(lib.ID()) != bstream.TruncateBlockID(state.LIBID)) {
+		return fmt.Errorf("state lib %s does not match last block %s from merged bundle %d", state.LIB(), lib, state.LastMergedBundle)
+	}
+
+	m.logger.Info("resuming from state file",
+		zap.Uint64("state_base_block_num", state.BaseBlockNum),
+		zap.Uint64("base_block_num", base),
+		zap.Stringer("lib", lib),
+	)
+	m.bundler.Reset(base, lib)
+	return nil
+}
+
+func (m *Merger) saveState(baseBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+	if len(oneBlockFiles) == 0 {
+		return
+	}
+	// the last block passed to MergeAndStore is the LIB of the next bundle, even when it was excluded from an empty bundle
+	lastBlock := oneBlockFiles[len(oneBlockFiles)-1]
+	state := &State{
+		BaseBlockNum:     baseBlockNum + m.bundler.bundleSize,
+		LIBNum:           lastBlock.Num,
+		LIBID:            lastBlock.ID,
+		LastMergedBundle: baseBlockNum,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), WriteObjectTimeout)
+	defer cancel()
+	if err := m.stateFile.Save(ctx, state); err != nil {
+		m.logger.Warn("cannot save state file", zap.Uint64("base_block_num", state.BaseBlockNum), zap.Error(err))
+	}
+}
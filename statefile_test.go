@@ -0,0 +1,95 @@
+package merger
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFile_SaveLoad(t *testing.T) {
+	stateFile, err := NewStateFile(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+
+	state, err := stateFile.Load(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	expected := &State{BaseBlockNum: 300, LIBNum: 299, LIBID: "0000000000000299a", LastMergedBundle: 200}
+	require.NoError(t, stateFile.Save(context.Background(), expected))
+	require.NoError(t, stateFile.Save(context.Background(), expected)) // overwrite
+
+	state, err = stateFile.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, state)
+}
+
+func TestMerger_ResumeFromState(t *testing.T) {
+	tests := []struct {
+		name       string
+		mergedLIB  bstream.BlockRef
+		mergedBase uint64
+		expectBase uint64
+	}{
+		{
+			name:       "agrees",
+			mergedBase: 300,
+			mergedLIB:  bstream.NewBlockRef("0000000000000299a", 299),
+			expectBase: 300,
+		},
+		{
+			name:       "merged store went further",
+			mergedBase: 500,
+			mergedLIB:  bstream.NewBlockRef("0000000000000499a", 499),
+			expectBase: 500,
+		},
+		{
+			name:       "different lib",
+			mergedBase: 300,
+			mergedLIB:  bstream.NewBlockRef("0000000000000299b", 299),
+			expectBase: 0,
+		},
+		{
+			name:       "missing merged files",
+			mergedBase: 200,
+			mergedLIB:  bstream.NewBlockRef("0000000000000199a", 199),
+			expectBase: 0,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			stateFile, err := NewStateFile(filepath.Join(t.TempDir(), "state.json"))
+			require.NoError(t, err)
+			require.NoError(t, stateFile.Save(context.Background(), &State{BaseBlockNum: 300, LIBNum: 299, LIBID: "0000000000000299a", LastMergedBundle: 200}))
+
+			io := &TestMergerIO{
+				NextBundleFunc: func(_ context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+					assert.EqualValues(t, 200, lowestBaseBlock)
+					return c.mergedBase, c.mergedLIB, nil
+				},
+			}
+			m := NewMerger(testLogger, "", io, 0, 100, 100, time.Second, time.Second, 0, WithStateFile(stateFile))
+			defer m.Shutdown(nil)
+			assert.EqualValues(t, c.expectBase, m.bundler.baseBlockNum)
+		})
+	}
+}
+
+func TestMerger_SaveState(t *testing.T) {
+	stateFile, err := NewStateFile(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 0, 100, 100, time.Second, time.Second, 0, WithStateFile(stateFile))
+	defer m.Shutdown(nil)
+
+	m.bundler.bundleMerged(100, []*bstream.OneBlockFile{block100, block101})
+
+	state, err := stateFile.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &State{BaseBlockNum: 200, LIBNum: 101, LIBID: block101.ID, LastMergedBundle: 100}, state)
+}
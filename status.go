@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// startStatusServer serves the status of the merger as JSON over HTTP:
+//
+//	GET /status/sources  statistics of each source of one-block-files, see SourceStats
+func (m *Merger) startStatusServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status/sources", m.serveSourceStats)
+
+	srv := &http.Server{Addr: m.statusListenAddr, Handler: mux}
+	m.OnTerminated(func(_ error) {
+		srv.Close()
+	})
+
+	m.logger.Info("starting status server", zap.String("listen_addr", m.statusListenAddr))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			m.logger.Warn("status server failed", zap.Error(err))
+		}
+	}()
+}
+
+func (m *Merger) serveSourceStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.bundler.SourceStats()); err != nil {
+		m.logger.Debug("cannot write source stats", zap.Error(err))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/merger/metrics"
 	"go.uber.org/zap"
 	"gopkg.in/olivere/elastic.v3/backoff"
 )
@@ -46,6 +47,7 @@ func Retry(logger *zap.Logger, attempts int, sleep time.Duration, callback func(
 
 		time.Sleep(b.Next())
 
+		metrics.RetryAttempts.Inc()
 		logger.Warn("retrying after error", zap.Error(err))
 	}
 	return fmt.Errorf("after %d attempts, last error: %s", attempts, err)
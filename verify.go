@@ -0,0 +1,216 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+const (
+	IssueBrokenLink    = "broken_link"    // previous ID of the block does not match the ID of the block before it
+	IssueDuplicate     = "duplicate"      // same block number seen twice
+	IssueOutOfOrder    = "out_of_order"   // block number lower than the block before it
+	IssueOutOfRange    = "out_of_range"   // block outside of [base, base+bundleSize)
+	IssueMissingBundle = "missing_bundle" // no merged file for that base block
+)
+
+type VerifyIssue struct {
+	Kind       string `json:"kind"`
+	Bundle     uint64 `json:"bundle"`
+	BlockNum   uint64 `json:"block_num,omitempty"`
+	BlockID    string `json:"block_id,omitempty"`
+	PreviousID string `json:"previous_id,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+}
+
+// VerifyReport is the result of a Verify pass, meant to be serialized as JSON
+type VerifyReport struct {
+	LowBlockNum  uint64         `json:"low_block_num"`
+	HighBlockNum uint64         `json:"high_block_num"`
+	Bundles      int            `json:"bundles"`
+	Blocks       int            `json:"blocks"`
+	Issues       []*VerifyIssue `json:"issues"`
+}
+
+func (r *VerifyReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Verify reads every block of the merged files between inclusiveLowBlock and exclusiveHighBlock, and checks that
+// each bundle only holds its own blocks, in ascending order, and that each block links to the previous one, across bundles.
+func (s *DStoreIO) Verify(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) (*VerifyReport, error) {
+	lowBase := toBaseNum(inclusiveLowBlock, s.bundleSize)
+	report := &VerifyReport{
+		LowBlockNum:  lowBase,
+		HighBlockNum: exclusiveHighBlock,
+		Issues:       []*VerifyIssue{},
+	}
+
+	var previous *bstream.Block
+	if lowBase >= s.bundleSize {
+		// link the first block to the end of the previous bundle, when we have it
+		reader, err := s.mergedBlocksStore.OpenObject(ctx, fileNameForBlocksBundle(lowBase-s.bundleSize))
+		if err == nil {
+			previous, err = lastBlock(reader)
+		}
+		if err != nil {
+			s.logger.Debug("cannot read bundle before verified range, not checking its link", zap.Error(err))
+		}
+	}
+
+	expectedBase := lowBase
+	err := s.mergedBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(lowBase), func(filename string) error {
+		base, err := strconv.ParseUint(filename, 10, 64)
+		if err != nil {
+			return nil // not a merged blocks file
+		}
+		if base >= exclusiveHighBlock {
+			return dstore.StopIteration
+		}
+
+		for ; expectedBase < base; expectedBase += s.bundleSize {
+			report.Issues = append(report.Issues, &VerifyIssue{Kind: IssueMissingBundle, Bundle: expectedBase})
+			previous = nil
+		}
+		expectedBase = base + s.bundleSize
+
+		report.Bundles++
+		previous, err = s.verifyBundle(ctx, base, previous, report)
+		return err
+	})
+	if err != nil && !errors.Is(err, dstore.StopIteration) {
+		return nil, err
+	}
+
+	for ; expectedBase < exclusiveHighBlock; expectedBase += s.bundleSize {
+		report.Issues = append(report.Issues, &VerifyIssue{Kind: IssueMissingBundle, Bundle: expectedBase})
+	}
+
+	return report, nil
+}
+
+func (s *DStoreIO) verifyBundle(ctx context.Context, base uint64, previous *bstream.Block, report *VerifyReport) (*bstream.Block, error) {
+	subCtx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
+	defer cancel()
+
+	reader, err := s.mergedBlocksStore.OpenObject(subCtx, fileNameForBlocksBundle(base))
+	if err != nil {
+		return nil, fmt.Errorf("opening merged file %s: %w", fileNameForBlocksBundle(base), err)
+	}
+	defer reader.Close()
+
+	blkReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged file %s: %w", fileNameForBlocksBundle(base), err)
+	}
+
+	for {
+		block, err := blkReader.Read()
+		if block != nil {
+			report.Blocks++
+			previous = verifyBlock(base, s.bundleSize, block, previous, report)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return previous, nil
+			}
+			return nil, fmt.Errorf("reading merged file %s: %w", fileNameForBlocksBundle(base), err)
+		}
+	}
+}
+
+// verifyBlock returns the block that the next one should link to
+func verifyBlock(base, bundleSize uint64, block, previous *bstream.Block, report *VerifyReport) *bstream.Block {
+	issue := func(kind string) *VerifyIssue {
+		i := &VerifyIssue{
+			Kind:       kind,
+			Bundle:     base,
+			BlockNum:   block.Number,
+			BlockID:    block.Id,
+			PreviousID: block.PreviousId,
+		}
+		report.Issues = append(report.Issues, i)
+		return i
+	}
+
+	if block.Number < base || block.Number >= base+bundleSize {
+		issue(IssueOutOfRange)
+	}
+
+	if previous == nil {
+		return block
+	}
+
+	switch {
+	case block.Number == previous.Number:
+		issue(IssueDuplicate).Expected = previous.Id
+		return previous
+	case block.Number < previous.Number:
+		issue(IssueOutOfOrder).Expected = fmt.Sprintf("> %d", previous.Number)
+		return previous
+	case block.PreviousId != previous.Id:
+		issue(IssueBrokenLink).Expected = previous.Id
+	}
+	return block
+}
+
+// checkMergedObject reads back a merged file that was just written and makes sure it contains exactly the
+// expected blocks, in order
+func (s *DStoreIO) checkMergedObject(ctx context.Context, filename string, expected []*bstream.OneBlockFile) error {
+	reader, err := s.mergedBlocksStore.OpenObject(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("opening merged file %s: %w", filename, err)
+	}
+	defer reader.Close()
+
+	blkReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return fmt.Errorf("reading merged file %s: %w", filename, err)
+	}
+
+	count := 0
+	for {
+		block, err := blkReader.Read()
+		if block != nil {
+			if count >= len(expected) {
+				return fmt.Errorf("merged file %s has more than the %d expected blocks", filename, len(expected))
+			}
+			obf := expected[count]
+			if block.Number != obf.Num || bstream.TruncateBlockID(block.Id) != bstream.TruncateBlockID(obf.ID) {
+				return fmt.Errorf("merged file %s has block #%d (%s) at position %d, expected #%d (%s)", filename, block.Number, block.Id, count, obf.Num, obf.ID)
+			}
+			count++
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("reading merged file %s: %w", filename, err)
+		}
+	}
+
+	if count != len(expected) {
+		return fmt.Errorf("merged file %s has %d blocks, expected %d", filename, count, len(expected))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMergedBundle(blocks ...string) []byte {
+	var lines []string
+	for _, blk := range blocks {
+		parts := strings.Split(blk, ":") // num:id:prev
+		lines = append(lines, fmt.Sprintf(`{"num":%s,"id":%q,"prev":%q}`, parts[0], parts[1], parts[2]))
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func TestDStoreIO_Verify(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.SetFile("0000000000", testMergedBundle("0:0a:", "1:1a:0a"))
+	mergedBlocksStore.SetFile("0000000002", testMergedBundle("2:2a:1a", "3:3a:2a"))
+	mergedBlocksStore.SetFile("0000000004", testMergedBundle("4:4a:3b", "4:4a:3b", "6:6a:4a"))
+	// 0000000006 missing
+	mergedBlocksStore.SetFile("0000000008", testMergedBundle("9:9a:8a", "8:8a:7a"))
+	mergedBlocksStore.SetFile("0000000010", testMergedBundle("10:10a:9a"))
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 2).(*DStoreIO)
+
+	report, err := mio.Verify(context.Background(), 2, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, report.Bundles)
+	assert.Equal(t, 7, report.Blocks)
+	assert.Equal(t, []*VerifyIssue{
+		{Kind: IssueBrokenLink, Bundle: 4, BlockNum: 4, BlockID: "4a", PreviousID: "3b", Expected: "3a"},
+		{Kind: IssueDuplicate, Bundle: 4, BlockNum: 4, BlockID: "4a", PreviousID: "3b", Expected: "4a"},
+		{Kind: IssueOutOfRange, Bundle: 4, BlockNum: 6, BlockID: "6a", PreviousID: "4a"},
+		{Kind: IssueMissingBundle, Bundle: 6},
+		{Kind: IssueOutOfOrder, Bundle: 8, BlockNum: 8, BlockID: "8a", PreviousID: "7a", Expected: "> 9"},
+	}, report.Issues)
+	assert.False(t, report.OK())
+
+	report, err = mio.Verify(context.Background(), 0, 4)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+}